@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	remoteLog "github.com/okteto/remote/pkg/log"
 	remoteOS "github.com/okteto/remote/pkg/os"
 	"github.com/okteto/remote/pkg/ssh"
 )
@@ -14,13 +22,28 @@ import (
 // CommitString is the commit used to build the server
 var CommitString string
 
-const (
-	authorizedKeysPath = "/var/okteto/remote/authorized_keys"
-)
+// defaultShutdownGrace is how long open sessions can run after SIGTERM. It's
+// under the default termination grace period of Kubernetes.
+const defaultShutdownGrace = 25 * time.Second
 
 func main() {
 	log.SetOutput(os.Stdout)
-	shell, err := remoteOS.GetShell()
+	formatter, err := remoteLog.NewFormatter(os.Getenv("OKTETO_REMOTE_LOG_FORMAT"))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	log.SetFormatter(formatter)
+	if b, ok := os.LookupEnv("OKTETO_REMOTE_ASYNC_LOG_BUFFER"); ok {
+		size, err := strconv.Atoi(b)
+		if err != nil || size <= 0 {
+			log.Fatalf("%s is not a valid log buffer size", b)
+		}
+
+		log.SetOutput(remoteLog.NewAsyncWriter(os.Stdout, size))
+	}
+
+	shell, err := remoteOS.GetShellWithFallback(os.Getenv("OKTETO_REMOTE_SHELL_FALLBACK"))
 	if err != nil {
 		log.Fatal(err.Error())
 	}
@@ -38,21 +61,304 @@ func main() {
 		}
 	}
 
-	keys, err := ssh.LoadAuthorizedKeys(authorizedKeysPath)
-	if err != nil {
+	srv := ssh.Server{
+		Port:               port,
+		BindAddress:        os.Getenv("OKTETO_REMOTE_BIND_ADDRESS"),
+		Shell:              shell,
+		AuthorizedKeysPath: ssh.DefaultAuthorizedKeysPath,
+		Version:            CommitString,
+	}
+
+	if p, ok := os.LookupEnv("OKTETO_REMOTE_AUTHORIZED_KEYS_PATH"); ok {
+		srv.AuthorizedKeysPath = p
+	}
+
+	srv.StrictModes = boolFromEnv("OKTETO_REMOTE_STRICT_MODES")
+	if k, ok := os.LookupEnv("OKTETO_REMOTE_AUTHORIZED_KEYS"); ok {
+		entries, err := ssh.ParseAuthorizedKeyEntries([]byte(k))
+		if err != nil {
+			log.Fatalf("Failed to parse OKTETO_REMOTE_AUTHORIZED_KEYS: %s", err)
+		}
+
+		if len(entries) == 0 {
+			log.Fatalf("OKTETO_REMOTE_AUTHORIZED_KEYS was empty")
+		}
+
+		srv.SetAuthorizedKeyEntries(entries)
+	} else if err := srv.LoadAuthorizedKeysFile(); err != nil {
 		log.Fatalf("Failed to load authorized_keys: %s", err)
 	}
 
-	if keys == nil {
+	if p, ok := os.LookupEnv("OKTETO_REMOTE_TRUSTED_CA_KEYS_PATH"); ok {
+		srv.TrustedUserCAKeys, err = ssh.LoadTrustedCAKeys(p)
+		if err != nil {
+			log.Fatalf("Failed to load the trusted CA keys: %s", err)
+		}
+	}
+
+	srv.Password = secretFromEnv("OKTETO_REMOTE_PASSWORD")
+	if srv.AuthorizedKeys == nil && srv.TrustedUserCAKeys == nil && srv.Password == "" {
 		log.Warningf("remote server is running without authentication enabled")
 	}
 
-	srv := ssh.Server{
-		Port:           port,
-		Shell:          shell,
-		AuthorizedKeys: keys,
+	if _, ok := os.LookupEnv("OKTETO_REMOTE_SFTP_REQUIRE_AUTH"); ok {
+		srv.AllowSFTPWithoutAuth = !boolFromEnv("OKTETO_REMOTE_SFTP_REQUIRE_AUTH")
+	}
+
+	if a, ok := os.LookupEnv("OKTETO_REMOTE_ALLOWED_ENV"); ok {
+		srv.AllowedEnv = strings.Split(a, ",")
+	}
+
+	if names, ok := os.LookupEnv("OKTETO_REMOTE_LOG_FIELD_NAMES"); ok {
+		srv.LogFieldNames = map[string]string{}
+		for _, pair := range strings.Split(names, ",") {
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("%s is not a valid field=name pair", pair)
+			}
+
+			srv.LogFieldNames[parts[0]] = parts[1]
+		}
+	}
+
+	if d, ok := os.LookupEnv("OKTETO_REMOTE_ENV_LOG_DENYLIST"); ok {
+		srv.EnvLogDenylist = strings.Split(d, ",")
+	}
+
+	srv.DisableSFTP = boolFromEnv("OKTETO_REMOTE_DISABLE_SFTP")
+	srv.SFTPReadOnly = boolFromEnv("OKTETO_REMOTE_SFTP_READ_ONLY")
+	srv.SFTPUmask = modeFromEnv("OKTETO_REMOTE_SFTP_UMASK")
+	srv.SFTPDefaultFileMode = modeFromEnv("OKTETO_REMOTE_SFTP_FILE_MODE")
+	srv.SFTPDefaultDirMode = modeFromEnv("OKTETO_REMOTE_SFTP_DIR_MODE")
+	srv.ReusePort = boolFromEnv("OKTETO_REMOTE_REUSE_PORT")
+	srv.TCPFastOpen = boolFromEnv("OKTETO_REMOTE_TCP_FAST_OPEN")
+
+	if d, ok := os.LookupEnv("OKTETO_REMOTE_SFTP_ROOT"); ok {
+		srv.SFTPRoot = d
+	}
+
+	if p, ok := os.LookupEnv("OKTETO_REMOTE_HOST_KEY_PATH"); ok {
+		srv.HostKeyPath = p
+	}
+
+	srv.HostKeyFallbackToEmbedded = boolFromEnv("OKTETO_REMOTE_HOST_KEY_FALLBACK_EMBEDDED")
+	if files, ok := os.LookupEnv("OKTETO_REMOTE_HOST_KEY_FILES"); ok {
+		for _, f := range strings.Split(files, ",") {
+			key, err := ioutil.ReadFile(f)
+			if err != nil {
+				log.Fatalf("Failed to read host key: %s", err)
+			}
+
+			srv.HostKeys = append(srv.HostKeys, key)
+		}
+	}
+
+	srv.HostKeyPassphrase = secretFromEnv("OKTETO_REMOTE_HOST_KEY_PASSPHRASE")
+	if p, ok := os.LookupEnv("OKTETO_REMOTE_HOST_KEY_PASSPHRASE_FILE"); ok {
+		passphrase, err := ioutil.ReadFile(p)
+		if err != nil {
+			log.Fatalf("Failed to read the host key passphrase: %s", err)
+		}
+
+		srv.HostKeyPassphrase = strings.TrimSpace(string(passphrase))
+	}
+
+	srv.HostKeyWait = durationFromEnv("OKTETO_REMOTE_HOST_KEY_WAIT")
+
+	srv.Banner = os.Getenv("OKTETO_REMOTE_BANNER")
+	srv.OpenBanner = os.Getenv("OKTETO_REMOTE_OPEN_BANNER")
+	if p, ok := os.LookupEnv("OKTETO_REMOTE_BANNER_FILE"); ok {
+		banner, err := ssh.LoadBanner(p)
+		if err != nil {
+			log.Fatalf("Failed to load the banner: %s", err)
+		}
+
+		srv.Banner = banner
+	}
+
+	if p, ok := os.LookupEnv("OKTETO_REMOTE_EXEC_PREFIX"); ok {
+		srv.ExecPrefix = p
+	}
+
+	if s, ok := os.LookupEnv("OKTETO_REMOTE_SHELL_SUBSYSTEMS"); ok {
+		srv.ShellSubsystems = strings.Split(s, ",")
+	}
+
+	if s, ok := os.LookupEnv("OKTETO_REMOTE_ALLOWED_SUBSYSTEMS"); ok {
+		srv.AllowedSubsystems = strings.Split(s, ",")
+	}
+
+	if boolFromEnv("OKTETO_REMOTE_PASSWD_SHELL") {
+		srv.ShellResolver = ssh.PasswdShell
 	}
 
-	log.Infof("ssh server %s started in 0.0.0.0:%d", CommitString, srv.Port)
-	log.Fatal(srv.ListenAndServe())
+	srv.QuietRoutine = boolFromEnv("OKTETO_REMOTE_QUIET_ROUTINE")
+	srv.RequireAgentForwarding = boolFromEnv("OKTETO_REMOTE_REQUIRE_AGENT_FORWARDING")
+	srv.RefuseRoot = boolFromEnv("OKTETO_REMOTE_REFUSE_ROOT")
+	srv.EnablePing = boolFromEnv("OKTETO_REMOTE_ENABLE_PING")
+	srv.DisableServerEnv = boolFromEnv("OKTETO_REMOTE_DISABLE_SERVER_ENV")
+	srv.ReapOrphans = boolFromEnv("OKTETO_REMOTE_REAP_ORPHANS")
+	srv.NormalizeCRLF = boolFromEnv("OKTETO_REMOTE_NORMALIZE_CRLF")
+	srv.DisablePTY = boolFromEnv("OKTETO_REMOTE_DISABLE_PTY")
+	srv.DisableLocalForwarding = boolFromEnv("OKTETO_REMOTE_DISABLE_LOCAL_FORWARDING")
+	srv.DisableReverseForwarding = boolFromEnv("OKTETO_REMOTE_DISABLE_REVERSE_FORWARDING")
+	srv.DisableInteractiveShell = boolFromEnv("OKTETO_REMOTE_DISABLE_INTERACTIVE_SHELL")
+	srv.HealthPort = intFromEnv("OKTETO_REMOTE_HEALTH_PORT")
+	srv.SessionBufferBytes = intFromEnv("OKTETO_REMOTE_SESSION_BUFFER_BYTES")
+	srv.MaxSessions = intFromEnv("OKTETO_REMOTE_MAX_SESSIONS")
+	srv.MaxEnvSize = intFromEnv("OKTETO_REMOTE_MAX_ENV_SIZE")
+	srv.MaxEnvVars = intFromEnv("OKTETO_REMOTE_MAX_ENV_VARS")
+	srv.DropExtraEnvVars = boolFromEnv("OKTETO_REMOTE_DROP_EXTRA_ENV_VARS")
+	srv.MaxCommandLength = intFromEnv("OKTETO_REMOTE_MAX_COMMAND_LENGTH")
+	srv.MaxForwardBytesPerSec = int64(intFromEnv("OKTETO_REMOTE_MAX_FORWARD_BYTES_PER_SEC"))
+
+	if d, ok := os.LookupEnv("OKTETO_REMOTE_FORWARD_ALLOWLIST"); ok {
+		srv.ForwardAllowlist = strings.Split(d, ",")
+	}
+
+	srv.AliveInterval = durationFromEnv("OKTETO_REMOTE_ALIVE_INTERVAL")
+	srv.KeepAliveInterval = durationFromEnv("OKTETO_REMOTE_KEEPALIVE_INTERVAL")
+	srv.KeepAliveCountMax = intFromEnv("OKTETO_REMOTE_KEEPALIVE_COUNT_MAX")
+	srv.FirstOutputTimeout = durationFromEnv("OKTETO_REMOTE_FIRST_OUTPUT_TIMEOUT")
+	srv.IdleTimeout = durationFromEnv("OKTETO_REMOTE_IDLE_TIMEOUT")
+	srv.ReadTimeout = durationFromEnv("OKTETO_REMOTE_READ_TIMEOUT")
+	srv.WriteTimeout = durationFromEnv("OKTETO_REMOTE_WRITE_TIMEOUT")
+	srv.SessionIdleTimeout = durationFromEnv("OKTETO_REMOTE_SESSION_IDLE_TIMEOUT")
+	srv.TimeoutExitCode = intFromEnv("OKTETO_REMOTE_TIMEOUT_EXIT_CODE")
+	srv.KillGrace = durationFromEnv("OKTETO_REMOTE_KILL_GRACE")
+	srv.KillOnFirstOutputTimeout = boolFromEnv("OKTETO_REMOTE_KILL_ON_FIRST_OUTPUT_TIMEOUT")
+	srv.MaxCols = intFromEnv("OKTETO_REMOTE_MAX_COLS")
+	srv.MaxRows = intFromEnv("OKTETO_REMOTE_MAX_ROWS")
+
+	if d, ok := os.LookupEnv("OKTETO_REMOTE_SCRATCH_DIR_BASE"); ok {
+		srv.ScratchDirBase = d
+	}
+
+	if c, ok := os.LookupEnv("OKTETO_REMOTE_PRE_CLOSE_COMMAND"); ok {
+		srv.PreCloseCommand = c
+	}
+
+	if d, ok := os.LookupEnv("OKTETO_REMOTE_PER_SESSION_LOG_DIR"); ok {
+		srv.PerSessionLogDir = d
+	}
+
+	if d, ok := os.LookupEnv("OKTETO_REMOTE_SESSION_RECORD_DIR"); ok {
+		srv.SessionRecordDir = d
+	}
+
+	if p, ok := os.LookupEnv("OKTETO_REMOTE_ACCESS_LOG"); ok {
+		f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("Failed to open the access log: %s", err)
+		}
+
+		srv.AccessLog = f
+	}
+
+	if p, ok := os.LookupEnv("OKTETO_REMOTE_ADMIN_SOCKET"); ok {
+		srv.AdminSocketPath = p
+	}
+
+	if p, ok := os.LookupEnv("OKTETO_REMOTE_AUDIT_LOG"); ok {
+		srv.AuditLogPath = p
+	}
+
+	srv.ReadinessCommand = os.Getenv("OKTETO_REMOTE_READINESS_COMMAND")
+	srv.ReadinessInterval = durationFromEnv("OKTETO_REMOTE_READINESS_INTERVAL")
+
+	if p, ok := os.LookupEnv("OKTETO_REMOTE_EVENT_SOCKET"); ok {
+		srv.EventSocketPath = p
+	}
+
+	bindAddress := srv.BindAddress
+	if bindAddress == "" {
+		bindAddress = "0.0.0.0"
+	}
+
+	shutdownGrace := defaultShutdownGrace
+	if _, ok := os.LookupEnv("OKTETO_REMOTE_SHUTDOWN_GRACE"); ok {
+		shutdownGrace = durationFromEnv("OKTETO_REMOTE_SHUTDOWN_GRACE")
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+
+	log.Infof("ssh server %s started in %s", CommitString, net.JoinHostPort(bindAddress, strconv.Itoa(srv.Port)))
+	go func() {
+		if err := srv.ListenAndServe(); err != ssh.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := <-stop
+	log.Infof("received %s, waiting up to %s for the open sessions to finish", sig, shutdownGrace)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.WithError(err).Warning("sessions were still open at shutdown")
+	}
+}
+
+func boolFromEnv(name string) bool {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return false
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("%s=%s is not a valid boolean", name, v)
+	}
+
+	return b
+}
+
+func intFromEnv(name string) int {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return 0
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		log.Fatalf("%s=%s is not a valid number", name, v)
+	}
+
+	return i
+}
+
+func modeFromEnv(name string) os.FileMode {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return 0
+	}
+
+	m, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || m > 0777 {
+		log.Fatalf("%s=%s is not a valid octal mode", name, v)
+	}
+
+	return os.FileMode(m)
+}
+
+func durationFromEnv(name string) time.Duration {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return 0
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("%s=%s is not a valid duration", name, v)
+	}
+
+	return d
+}
+
+// secretFromEnv returns the value of name and removes it from the
+// environment, see the Secrets section of the README
+func secretFromEnv(name string) string {
+	v := os.Getenv(name)
+	os.Unsetenv(name)
+	return v
 }
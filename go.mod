@@ -6,14 +6,14 @@ require (
 	github.com/creack/pty v1.1.11
 	github.com/gliderlabs/ssh v0.3.1
 	github.com/google/uuid v1.1.2
-	github.com/pkg/sftp v1.12.0
+	github.com/pkg/sftp v1.13.6
 	github.com/sirupsen/logrus v1.7.0
 	golang.org/x/crypto v0.22.0
+	golang.org/x/sys v0.19.0
 )
 
 require (
 	github.com/anmitsu/go-shlex v0.0.0-20200514113438-38f4b401e2be // indirect
 	github.com/kr/fs v0.1.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	golang.org/x/sys v0.19.0 // indirect
 )
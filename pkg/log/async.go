@@ -0,0 +1,67 @@
+package log
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncWriter writes to the underlying writer from a background goroutine, so
+// a slow or stuck log sink never blocks the caller. Lines are dropped when
+// the buffer is full.
+type AsyncWriter struct {
+	w       io.Writer
+	ch      chan []byte
+	dropped uint64
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewAsyncWriter returns an AsyncWriter that buffers up to size writes
+func NewAsyncWriter(w io.Writer, size int) *AsyncWriter {
+	a := &AsyncWriter{
+		w:    w,
+		ch:   make(chan []byte, size),
+		done: make(chan struct{}),
+	}
+
+	go a.run()
+	return a
+}
+
+func (a *AsyncWriter) run() {
+	defer close(a.done)
+	for p := range a.ch {
+		a.w.Write(p)
+	}
+}
+
+// Write queues p to be written. It never blocks and never fails.
+func (a *AsyncWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	copy(buf, p)
+
+	select {
+	case a.ch <- buf:
+	default:
+		atomic.AddUint64(&a.dropped, 1)
+	}
+
+	return len(p), nil
+}
+
+// Dropped returns the number of writes discarded because the buffer was full
+func (a *AsyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Close flushes the pending writes and stops the background goroutine.
+// It must not be called while writes are in flight.
+func (a *AsyncWriter) Close() error {
+	a.once.Do(func() {
+		close(a.ch)
+	})
+
+	<-a.done
+	return nil
+}
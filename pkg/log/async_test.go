@@ -0,0 +1,44 @@
+package log
+
+import (
+	"bytes"
+	"testing"
+)
+
+type blockedWriter struct {
+	unblock chan struct{}
+}
+
+func (b *blockedWriter) Write(p []byte) (int, error) {
+	<-b.unblock
+	return len(p), nil
+}
+
+func TestAsyncWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewAsyncWriter(&buf, 10)
+	w.Write([]byte("hello\n"))
+	w.Close()
+
+	if buf.String() != "hello\n" {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+func TestAsyncWriter_blocked(t *testing.T) {
+	sink := &blockedWriter{unblock: make(chan struct{})}
+	w := NewAsyncWriter(sink, 2)
+
+	for i := 0; i < 10; i++ {
+		if _, err := w.Write([]byte("line\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if w.Dropped() == 0 {
+		t.Error("writes to a blocked sink weren't dropped")
+	}
+
+	close(sink.unblock)
+	w.Close()
+}
@@ -0,0 +1,33 @@
+package log
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The supported log formats
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// NewFormatter returns the logrus formatter for format
+func NewFormatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case "", FormatText:
+		return &logrus.TextFormatter{}, nil
+	case FormatJSON:
+		return &logrus.JSONFormatter{}, nil
+	case FormatLogfmt:
+		// the text formatter writes logfmt when colors are disabled
+		return &logrus.TextFormatter{
+			DisableColors:    true,
+			FullTimestamp:    true,
+			QuoteEmptyFields: true,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%s is not a valid log format, use %s, %s or %s", format, FormatText, FormatJSON, FormatLogfmt)
+	}
+}
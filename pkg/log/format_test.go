@@ -0,0 +1,87 @@
+package log
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// parseLogfmt is a minimal logfmt parser supporting quoted values
+func parseLogfmt(t *testing.T, line string) map[string]string {
+	fields := map[string]string{}
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			t.Fatalf("bad logfmt pair: %s", line)
+		}
+
+		key := line[:eq]
+		line = line[eq+1:]
+
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				t.Fatalf("bad quoted value: %s", line)
+			}
+
+			value, _ = strconv.Unquote(quoted)
+			line = line[len(quoted):]
+		} else if sp := strings.Index(line, " "); sp >= 0 {
+			value, line = line[:sp], line[sp:]
+		} else {
+			value, line = line, ""
+		}
+
+		fields[key] = value
+	}
+
+	return fields
+}
+
+func TestNewFormatter_logfmt(t *testing.T) {
+	f, err := NewFormatter(FormatLogfmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(f)
+	logger.WithFields(logrus.Fields{
+		"session.id": "1234",
+		"user":       "okteto",
+		"duration":   "1.5s",
+		"command":    "echo hello world",
+	}).Info("session closed")
+
+	fields := parseLogfmt(t, buf.String())
+	expected := map[string]string{
+		"level":      "info",
+		"msg":        "session closed",
+		"session.id": "1234",
+		"user":       "okteto",
+		"duration":   "1.5s",
+		"command":    "echo hello world",
+	}
+
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("%s: got %q, expected %q", k, fields[k], v)
+		}
+	}
+
+	if fields["time"] == "" {
+		t.Error("time is missing")
+	}
+}
+
+func TestNewFormatter_invalid(t *testing.T) {
+	if _, err := NewFormatter("xml"); err == nil {
+		t.Error("invalid format didn't fail")
+	}
+}
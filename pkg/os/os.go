@@ -10,19 +10,38 @@ import (
 var (
 	// ErrNoShell is used when there is no shell available in the $PATH
 	ErrNoShell = fmt.Errorf("bash or sh needs to be available in the $PATH of your development container")
+
+	lookPath = exec.LookPath
 )
 
 // GetShell returns the available shell
 func GetShell() (string, error) {
-	if p, err := exec.LookPath("bash"); err == nil {
+	if p, err := lookPath("bash"); err == nil {
 		log.Printf("bash exists at %s", p)
 		return "bash", nil
 	}
 
-	if p, err := exec.LookPath("sh"); err == nil {
+	if p, err := lookPath("sh"); err == nil {
 		log.Printf("sh exists at %s", p)
 		return "sh", nil
 	}
 
 	return "", ErrNoShell
 }
+
+// GetShellWithFallback returns the available shell, or fallback if neither
+// bash nor sh are available. An empty fallback disables it.
+func GetShellWithFallback(fallback string) (string, error) {
+	shell, err := GetShell()
+	if err == nil || fallback == "" {
+		return shell, err
+	}
+
+	p, lookErr := lookPath(fallback)
+	if lookErr != nil {
+		return "", fmt.Errorf("%s, and the fallback shell %s is not available: %w", err, fallback, lookErr)
+	}
+
+	log.Warningf("%s, falling back to %s", err, p)
+	return fallback, nil
+}
@@ -0,0 +1,74 @@
+package os
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func stubLookPath(t *testing.T, available ...string) {
+	t.Cleanup(func() { lookPath = exec.LookPath })
+	lookPath = func(file string) (string, error) {
+		for _, a := range available {
+			if a == file {
+				return "/bin/" + file, nil
+			}
+		}
+
+		return "", exec.ErrNotFound
+	}
+}
+
+func TestGetShell(t *testing.T) {
+	var tests = []struct {
+		name      string
+		available []string
+		expected  string
+		expectErr bool
+	}{
+		{name: "bash", available: []string{"bash", "sh"}, expected: "bash"},
+		{name: "sh", available: []string{"sh"}, expected: "sh"},
+		{name: "none", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubLookPath(t, tt.available...)
+			shell, err := GetShell()
+			if tt.expectErr {
+				if err != ErrNoShell {
+					t.Fatalf("expected ErrNoShell, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if shell != tt.expected {
+				t.Errorf("got %s, expected %s", shell, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetShellWithFallback(t *testing.T) {
+	stubLookPath(t, "ash")
+	shell, err := GetShellWithFallback("ash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shell != "ash" {
+		t.Errorf("got %s, expected ash", shell)
+	}
+
+	if _, err := GetShellWithFallback(""); err != ErrNoShell {
+		t.Errorf("expected ErrNoShell without a fallback, got %v", err)
+	}
+
+	if _, err := GetShellWithFallback("zsh"); err == nil {
+		t.Error("unavailable fallback didn't fail")
+	}
+}
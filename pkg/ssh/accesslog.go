@@ -0,0 +1,63 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxAccessLogCommand is the length after which commands are truncated in the
+// access log
+const maxAccessLogCommand = 256
+
+var accessLogMu sync.Mutex
+
+// accessLogLine formats a session as a single access log line:
+//
+//	<RFC3339 start time> <remote IP> <user> <shell|exec> "<command>" <exit code> <duration>ms
+//
+// Missing values are written as "-", and commands are redacted, quoted and
+// truncated to maxAccessLogCommand characters.
+func (srv *Server) accessLogLine(s ssh.Session, start time.Time, exitCode int) string {
+	ip := s.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	user := s.User()
+	if user == "" {
+		user = "-"
+	}
+
+	sessionType := "exec"
+	command := srv.redact(s.RawCommand())
+	if command == "" {
+		sessionType = "shell"
+		command = "-"
+	} else if len(command) > maxAccessLogCommand {
+		command = command[:maxAccessLogCommand] + "..."
+	}
+
+	return fmt.Sprintf("%s %s %s %s %q %d %dms\n", start.UTC().Format(time.RFC3339), ip, user, sessionType, command, exitCode, time.Since(start).Milliseconds())
+}
+
+func (srv *Server) writeAccessLog(s ssh.Session, start time.Time, exitCode int) {
+	if srv.AccessLog == nil {
+		return
+	}
+
+	line := srv.accessLogLine(s, start, exitCode)
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	if _, err := io.WriteString(srv.AccessLog, line); err != nil {
+		// a broken access log would fail the same way for every session
+		srv.accessLogFailed.Do(func() {
+			log.WithError(err).Error("failed to write the access log")
+		})
+	}
+}
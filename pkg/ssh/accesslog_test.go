@@ -0,0 +1,74 @@
+package ssh
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_accessLog(t *testing.T) {
+	var tests = []struct {
+		name     string
+		command  string
+		expected string
+	}{
+		{name: "exec", command: "echo hi", expected: `^\S+ 127\.0\.0\.1 okteto exec "echo hi" 0 \d+ms\n$`},
+		{name: "exit-code", command: "exit 3", expected: `^\S+ 127\.0\.0\.1 okteto exec "exit 3" 3 \d+ms\n$`},
+		{name: "truncated", command: "true " + strings.Repeat("x", 300), expected: `^\S+ 127\.0\.0\.1 okteto exec "true x{251}\.\.\." 0 \d+ms\n$`},
+		{name: "redacted", command: "true password=hunter2", expected: `^\S+ 127\.0\.0\.1 okteto exec "true \*\*\*\*" 0 \d+ms\n$`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessLog := &syncBuffer{}
+			s := &Server{Shell: "sh", AccessLog: accessLog}
+			session, _, cleanup := newTestSession(t, s.getServer(), &gossh.ClientConfig{User: "okteto"})
+			defer cleanup()
+
+			session.Run(tt.command)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for accessLog.String() == "" && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if !regexp.MustCompile(tt.expected).MatchString(accessLog.String()) {
+				t.Errorf("access log %q doesn't match %s", accessLog.String(), tt.expected)
+			}
+		})
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func Test_accessLog_writeError(t *testing.T) {
+	logs := captureLogs(t)
+	s := &Server{Shell: "sh", AccessLog: failingWriter{}}
+	session, client, cleanup := newTestSession(t, s.getServer(), &gossh.ClientConfig{User: "okteto"})
+	defer cleanup()
+
+	session.Run("true")
+	for i := 0; i < 2; i++ {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		session.Run("true")
+		session.Close()
+	}
+
+	waitForLog(t, logs, "failed to write the access log")
+	time.Sleep(100 * time.Millisecond)
+	if n := strings.Count(logs.String(), "failed to write the access log"); n != 1 {
+		t.Errorf("the write error was logged %d times:\n%s", n, logs.String())
+	}
+}
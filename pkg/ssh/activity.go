@@ -0,0 +1,96 @@
+package ssh
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// activityMonitor calls onIdle once when there is no traffic in either
+// direction of a session for timeout
+type activityMonitor struct {
+	timeout time.Duration
+	timer   *time.Timer
+
+	mu      sync.Mutex
+	expired bool
+}
+
+func newActivityMonitor(timeout time.Duration, onIdle func()) *activityMonitor {
+	a := &activityMonitor{timeout: timeout}
+	a.timer = time.AfterFunc(timeout, func() {
+		a.mu.Lock()
+		a.expired = true
+		a.mu.Unlock()
+		onIdle()
+	})
+
+	return a
+}
+
+func (a *activityMonitor) touch() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.expired {
+		a.timer.Reset(a.timeout)
+	}
+}
+
+func (a *activityMonitor) stop() {
+	a.timer.Stop()
+}
+
+// idle returns true if the session was idle for longer than the timeout
+func (a *activityMonitor) idle() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.expired
+}
+
+// wrap returns s with its stdin, stdout and stderr reporting activity
+func (a *activityMonitor) wrap(s ssh.Session) ssh.Session {
+	return &activitySession{Session: s, monitor: a}
+}
+
+type activitySession struct {
+	ssh.Session
+	monitor *activityMonitor
+}
+
+func (s *activitySession) Read(p []byte) (int, error) {
+	n, err := s.Session.Read(p)
+	if n > 0 {
+		s.monitor.touch()
+	}
+
+	return n, err
+}
+
+func (s *activitySession) Write(p []byte) (int, error) {
+	n, err := s.Session.Write(p)
+	if n > 0 {
+		s.monitor.touch()
+	}
+
+	return n, err
+}
+
+func (s *activitySession) Stderr() io.ReadWriter {
+	return &activityStderr{ReadWriter: s.Session.Stderr(), monitor: s.monitor}
+}
+
+type activityStderr struct {
+	io.ReadWriter
+	monitor *activityMonitor
+}
+
+func (s *activityStderr) Write(p []byte) (int, error) {
+	n, err := s.ReadWriter.Write(p)
+	if n > 0 {
+		s.monitor.touch()
+	}
+
+	return n, err
+}
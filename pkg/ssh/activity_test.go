@@ -0,0 +1,147 @@
+package ssh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_connectionHandler_sessionIdleTimeout(t *testing.T) {
+	var tests = []struct {
+		name     string
+		command  string
+		expected string
+	}{
+		{name: "output-only", command: "for i in 1 2 3 4 5 6 7 8; do echo $i; sleep 0.1; done", expected: EndReasonExit},
+		{name: "stderr-only", command: "for i in 1 2 3 4 5 6 7 8; do echo $i >&2; sleep 0.1; done", expected: EndReasonExit},
+		{name: "idle", command: "sleep 5", expected: EndReasonIdleTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+
+			s := &Server{Shell: "sh", SessionIdleTimeout: 300 * time.Millisecond}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			var stdout bytes.Buffer
+			session.Stdout = &stdout
+			start := time.Now()
+			err := session.Run(tt.command)
+			if tt.expected == EndReasonExit && err != nil {
+				t.Fatal(err)
+			}
+
+			if time.Since(start) > 3*time.Second {
+				t.Errorf("session ran for %s", time.Since(start))
+			}
+
+			waitForLog(t, logs, "session closed")
+			if !strings.Contains(logs.String(), "end.reason="+tt.expected) {
+				t.Errorf("expected end.reason=%s:\n%s", tt.expected, logs.String())
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_ptyIdleTimeoutExit(t *testing.T) {
+	var tests = []struct {
+		name     string
+		code     int
+		expected int
+	}{
+		{name: "default", expected: ExitCodeTimeout},
+		{name: "configured", code: 42, expected: 42},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+
+			s := &Server{Shell: "sh", SessionIdleTimeout: 300 * time.Millisecond, TimeoutExitCode: tt.code}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			if err := session.RequestPty("xterm", 40, 80, gossh.TerminalModes{}); err != nil {
+				t.Fatal(err)
+			}
+
+			var stdout bytes.Buffer
+			session.Stdout = &stdout
+			err := session.Run("sleep 5")
+			exitErr, ok := err.(*gossh.ExitError)
+			if !ok || exitErr.ExitStatus() != tt.expected {
+				t.Fatalf("expected exit code %d, got %v", tt.expected, err)
+			}
+
+			if !strings.Contains(stdout.String(), "session terminated after 300ms without activity") {
+				t.Errorf("the timeout wasn't reported to the terminal: %q", stdout.String())
+			}
+
+			waitForLog(t, logs, "session closed")
+			if !strings.Contains(logs.String(), "end.reason="+EndReasonIdleTimeout) {
+				t.Errorf("expected end.reason=%s:\n%s", EndReasonIdleTimeout, logs.String())
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_ptyConnectionIdleTimeout(t *testing.T) {
+	logs := captureLogs(t)
+
+	s := &Server{Shell: "sh", IdleTimeout: 300 * time.Millisecond, SessionIdleTimeout: time.Minute}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.RequestPty("xterm", 40, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Run("sleep 5"); err == nil {
+		t.Fatal("the session wasn't closed")
+	}
+
+	waitForLog(t, logs, "session closed")
+	if strings.Contains(logs.String(), "failed to write timeout") || strings.Contains(logs.String(), "without activity") {
+		t.Errorf("the session idle timeout was reported for a closed connection:\n%s", logs.String())
+	}
+}
+
+func Test_activityMonitor_input(t *testing.T) {
+	logs := captureLogs(t)
+
+	s := &Server{Shell: "sh", SessionIdleTimeout: 300 * time.Millisecond}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Start("cat > /dev/null"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 8; i++ {
+		if _, err := stdin.Write([]byte("data\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	stdin.Close()
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForLog(t, logs, "session closed")
+	if !strings.Contains(logs.String(), "end.reason="+EndReasonExit) {
+		t.Errorf("expected end.reason=%s:\n%s", EndReasonExit, logs.String())
+	}
+}
@@ -0,0 +1,205 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// activeSession is a session registered while its command runs
+type activeSession struct {
+	info  SessionInfo
+	start time.Time
+	kill  func()
+
+	mu      sync.Mutex
+	reason  string
+	running bool
+}
+
+// terminate kills the command of the session, which ends with reason. A
+// command that didn't start yet is killed as soon as it starts.
+func (a *activeSession) terminate(reason string) {
+	a.mu.Lock()
+	a.reason = reason
+	running := a.running
+	a.mu.Unlock()
+	if running {
+		a.kill()
+	}
+}
+
+// started records that the command of the session is running
+func (a *activeSession) started() {
+	a.mu.Lock()
+	a.running = true
+	reason := a.reason
+	a.mu.Unlock()
+	if reason != "" {
+		go a.kill()
+	}
+}
+
+// terminatedFor returns why the session was terminated, if it was
+func (a *activeSession) terminatedFor() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reason
+}
+
+// ActiveSession describes a session whose command is running
+type ActiveSession struct {
+	SessionInfo
+	Start time.Time
+}
+
+// ActiveSessions returns the sessions whose command is running, oldest first
+func (srv *Server) ActiveSessions() []ActiveSession {
+	var sessions []ActiveSession
+	srv.sessions.Range(func(_, s interface{}) bool {
+		a := s.(*activeSession)
+		sessions = append(sessions, ActiveSession{SessionInfo: a.info, Start: a.start})
+		return true
+	})
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Start.Before(sessions[j].Start) })
+	return sessions
+}
+
+// KillSession terminates the command of the session with id. It returns false
+// if there is no such session.
+func (srv *Server) KillSession(id string) bool {
+	s, ok := srv.sessions.Load(id)
+	if !ok {
+		return false
+	}
+
+	s.(*activeSession).terminate(EndReasonKilled)
+	return true
+}
+
+// listenAdmin listens on AdminSocketPath, replacing a stale socket left by a
+// previous run. Only the user running the server can connect to it: the
+// socket is bound in a private directory and moved into place once its
+// permissions are restricted, so it's never reachable with looser ones.
+func (srv *Server) listenAdmin() (net.Listener, error) {
+	if err := os.Remove(srv.AdminSocketPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir(filepath.Dir(srv.AdminSocketPath), ".admin-")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	bound := filepath.Join(dir, "admin.sock")
+	l, err := net.Listen("unix", bound)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(bound, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	if err := os.Rename(bound, srv.AdminSocketPath); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return &adminListener{Listener: l, path: srv.AdminSocketPath}, nil
+}
+
+// adminListener removes the socket when it's closed, the listener only knows
+// the name it was bound with
+type adminListener struct {
+	net.Listener
+	path string
+}
+
+func (l *adminListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+func (srv *Server) serveAdmin(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.WithError(err).Debug("admin socket closed")
+			return
+		}
+
+		go srv.handleAdmin(conn)
+	}
+}
+
+// handleAdmin runs the commands sent to the admin socket, one per line:
+//
+//	LIST       one line per active session, then OK
+//	KILL <id>  terminates the command of the session, then OK
+//	OUTPUT <id>
+//	           the quoted tail of the output of the session, then OK, see
+//	           Server.SessionBufferBytes
+//	READY      OK if the server is ready, see Server.Ready
+//
+// Failed commands are answered with ERR and the reason.
+func (srv *Server) handleAdmin(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case strings.EqualFold(fields[0], "LIST") && len(fields) == 1:
+			for _, s := range srv.ActiveSessions() {
+				fmt.Fprintf(conn, "%s %s %s %s %q\n", s.ID, s.User, s.RemoteAddr, s.Start.Format(time.RFC3339), srv.redact(s.Command))
+			}
+
+			fmt.Fprintln(conn, "OK")
+		case strings.EqualFold(fields[0], "KILL") && len(fields) == 2:
+			if !srv.KillSession(fields[1]) {
+				fmt.Fprintf(conn, "ERR session %s not found\n", fields[1])
+				continue
+			}
+
+			log.WithField("session.id", fields[1]).Info("session killed from the admin socket")
+			fmt.Fprintln(conn, "OK")
+		case strings.EqualFold(fields[0], "OUTPUT") && len(fields) == 2:
+			b, ok := srv.sessionBuffers.Load(fields[1])
+			if !ok {
+				fmt.Fprintf(conn, "ERR no output buffered for session %s\n", fields[1])
+				continue
+			}
+
+			fmt.Fprintf(conn, "%q\n", srv.redact(string(b.(*ringBuffer).Bytes())))
+			fmt.Fprintln(conn, "OK")
+		case strings.EqualFold(fields[0], "READY") && len(fields) == 1:
+			if !srv.Ready() {
+				fmt.Fprintln(conn, "ERR not ready")
+				continue
+			}
+
+			fmt.Fprintln(conn, "OK")
+		default:
+			fmt.Fprintf(conn, "ERR unknown command %q\n", scanner.Text())
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminCommand sends command to the admin socket at path and returns the
+// lines of the response, without the final OK
+func adminCommand(t *testing.T, path, command string) []string {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+	fmt.Fprintln(conn, command)
+
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "OK" {
+			return lines
+		}
+
+		if strings.HasPrefix(line, "ERR") {
+			t.Fatalf("%s failed: %s", command, line)
+		}
+
+		lines = append(lines, line)
+	}
+
+	t.Fatalf("%s didn't finish: %v", command, scanner.Err())
+	return nil
+}
+
+func Test_adminSocket(t *testing.T) {
+	s := &Server{Shell: "sh", AdminSocketPath: filepath.Join(t.TempDir(), "admin.sock")}
+	l, err := s.listenAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	go s.serveAdmin(l)
+
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Start("sleep 30"); err != nil {
+		t.Fatal(err)
+	}
+
+	var id string
+	for i := 0; i < 50 && id == ""; i++ {
+		if sessions := adminCommand(t, s.AdminSocketPath, "LIST"); len(sessions) == 1 {
+			id = strings.Fields(sessions[0])[0]
+			if !strings.HasSuffix(sessions[0], `"sleep 30"`) {
+				t.Errorf("bad session line: %s", sessions[0])
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if id == "" {
+		t.Fatal("session wasn't listed")
+	}
+
+	adminCommand(t, s.AdminSocketPath, "KILL "+id)
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("killed command exited successfully")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session wasn't closed after KILL")
+	}
+
+	if sessions := adminCommand(t, s.AdminSocketPath, "LIST"); len(sessions) != 0 {
+		t.Errorf("killed session is still listed: %v", sessions)
+	}
+}
+
+func Test_adminSocket_unknownSession(t *testing.T) {
+	s := &Server{AdminSocketPath: filepath.Join(t.TempDir(), "admin.sock")}
+	l, err := s.listenAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	go s.serveAdmin(l)
+
+	conn, err := net.Dial("unix", s.AdminSocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+	fmt.Fprintln(conn, "KILL missing")
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(line, "ERR") {
+		t.Errorf("got %q, expected an error", line)
+	}
+}
+
+func Test_activeSession_terminateBeforeStart(t *testing.T) {
+	killed := make(chan struct{}, 1)
+	a := &activeSession{kill: func() { killed <- struct{}{} }}
+	a.terminate(EndReasonKilled)
+
+	select {
+	case <-killed:
+		t.Fatal("the command was killed before it started")
+	default:
+	}
+
+	a.started()
+	select {
+	case <-killed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command wasn't killed once it started")
+	}
+
+	if reason := a.terminatedFor(); reason != EndReasonKilled {
+		t.Errorf("got reason %q, expected %q", reason, EndReasonKilled)
+	}
+}
+
+func Test_listenAdmin_permissions(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{AdminSocketPath: filepath.Join(dir, "admin.sock")}
+	l, err := s.listenAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(s.AdminSocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Errorf("got mode %s, expected a socket only its owner can use", fi.Mode())
+	}
+
+	go s.serveAdmin(l)
+	adminCommand(t, s.AdminSocketPath, "LIST")
+
+	l.Close()
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("the socket wasn't cleaned up: %v", entries)
+	}
+}
+
+func Test_adminSocket_output(t *testing.T) {
+	s := &Server{Shell: "sh", SessionBufferBytes: 16, AdminSocketPath: filepath.Join(t.TempDir(), "admin.sock")}
+	l, err := s.listenAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	go s.serveAdmin(l)
+
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Start("seq 1000; echo tail-0123456789; sleep 5"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if sessions := adminCommand(t, s.AdminSocketPath, "LIST"); len(sessions) == 1 {
+			id := strings.Fields(sessions[0])[0]
+			if output := adminCommand(t, s.AdminSocketPath, "OUTPUT "+id); len(output) == 1 && output[0] == `"tail-0123456789\n"` {
+				break
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the tail of the output wasn't returned")
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	conn, err := net.Dial("unix", s.AdminSocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+	fmt.Fprintln(conn, "OUTPUT missing")
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || !strings.HasPrefix(line, "ERR") {
+		t.Errorf("got %q, %v, expected an error for an unknown session", line, err)
+	}
+}
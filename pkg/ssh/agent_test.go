@@ -0,0 +1,60 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func Test_connectionHandler_agentListenerFails(t *testing.T) {
+	var tests = []struct {
+		name    string
+		require bool
+	}{
+		{name: "continue"},
+		{name: "required", require: true},
+	}
+
+	newAgentListener = func() (net.Listener, error) {
+		return nil, errors.New("no space left on device")
+	}
+	defer func() { newAgentListener = ssh.NewAgentListener }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", RequireAgentForwarding: tt.require}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			if err := agent.RequestAgentForwarding(session); err != nil {
+				t.Fatal(err)
+			}
+
+			var stdout bytes.Buffer
+			session.Stdout = &stdout
+			err := session.Run(`echo "ran $SSH_AUTH_SOCK"`)
+			if tt.require {
+				exitErr, ok := err.(*gossh.ExitError)
+				if !ok || exitErr.ExitStatus() != ExitCodeInternalError {
+					t.Fatalf("expected exit code %d, got %v", ExitCodeInternalError, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if out := strings.TrimSpace(stdout.String()); out != "ran" {
+				t.Errorf("got %q, expected the command to run without an agent", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package ssh
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+var auditLogMu sync.Mutex
+
+// AuditRecord is the JSON line written to AuditLogPath for every session
+type AuditRecord struct {
+	SessionID  string    `json:"session_id"`
+	User       string    `json:"user"`
+	RemoteAddr string    `json:"remote_address"`
+	Command    string    `json:"command"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	ExitCode   int       `json:"exit_code"`
+	Label      string    `json:"label,omitempty"`
+}
+
+// writeAuditLog appends record to AuditLogPath. The file is opened for every
+// record, so it can be rotated while the server runs.
+func (srv *Server) writeAuditLog(logger *log.Entry, record AuditRecord) {
+	if srv.AuditLogPath == "" {
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		logger.WithError(err).Error("failed to encode audit record")
+		return
+	}
+
+	auditLogMu.Lock()
+	defer auditLogMu.Unlock()
+	f, err := os.OpenFile(srv.AuditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logger.WithError(err).Error("failed to open the audit log")
+		return
+	}
+
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		logger.WithError(err).Error("failed to write the audit log")
+	}
+}
+
+func newAuditRecord(s ssh.Session, sessionID string, start time.Time, exitCode int) AuditRecord {
+	return AuditRecord{
+		SessionID:  sessionID,
+		User:       s.User(),
+		RemoteAddr: s.RemoteAddr().String(),
+		Command:    s.RawCommand(),
+		Start:      start,
+		End:        time.Now(),
+		ExitCode:   exitCode,
+		Label:      sessionLabel(s.Environ()),
+	}
+}
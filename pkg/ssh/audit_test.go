@@ -0,0 +1,64 @@
+package ssh
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_auditLog(t *testing.T) {
+	var tests = []struct {
+		name     string
+		command  string
+		exitCode int
+	}{
+		{name: "success", command: "echo hi", exitCode: 0},
+		{name: "failure", command: "exit 7", exitCode: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			s := &Server{Shell: "sh", AuditLogPath: path}
+			session, _, cleanup := newTestSession(t, s.getServer(), &gossh.ClientConfig{User: "okteto"})
+			defer cleanup()
+
+			before := time.Now()
+			session.Run(tt.command)
+
+			var content []byte
+			deadline := time.Now().Add(5 * time.Second)
+			for len(content) == 0 && time.Now().Before(deadline) {
+				content, _ = ioutil.ReadFile(path)
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("expected one audit record, got %q", content)
+			}
+
+			var record AuditRecord
+			if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+				t.Fatal(err)
+			}
+
+			if record.SessionID == "" || record.User != "okteto" || !strings.HasPrefix(record.RemoteAddr, "127.0.0.1:") {
+				t.Errorf("bad session fields: %+v", record)
+			}
+
+			if record.Command != tt.command || record.ExitCode != tt.exitCode {
+				t.Errorf("got command %q with exit code %d, expected %q with %d", record.Command, record.ExitCode, tt.command, tt.exitCode)
+			}
+
+			if record.Start.Before(before.Add(-time.Second)) || record.End.Before(record.Start) {
+				t.Errorf("bad times: %s - %s", record.Start, record.End)
+			}
+		})
+	}
+}
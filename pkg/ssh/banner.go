@@ -0,0 +1,50 @@
+package ssh
+
+import (
+	"io/ioutil"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// DefaultOpenBanner is shown to clients when authentication is disabled and
+// OpenBanner is empty
+const DefaultOpenBanner = "WARNING: this server has no authentication, anyone who can reach it can run commands\n"
+
+// LoadBanner returns the banner in the file at path, e.g. an authorized-use
+// notice, to be set as Banner
+func LoadBanner(path string) (string, error) {
+	banner, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return string(banner), nil
+}
+
+func (srv *Server) banner() string {
+	if srv.authEnabled() {
+		return srv.Banner
+	}
+
+	if srv.OpenBanner != "" {
+		return srv.OpenBanner
+	}
+
+	return DefaultOpenBanner
+}
+
+// serverConfig returns the crypto/ssh configuration from ServerConfigCallback,
+// with the banner for the authentication mode of the server
+func (srv *Server) serverConfig(ctx ssh.Context) *gossh.ServerConfig {
+	cfg := &gossh.ServerConfig{}
+	if srv.ServerConfigCallback != nil {
+		cfg = srv.ServerConfigCallback(ctx)
+	}
+
+	if banner := srv.banner(); banner != "" && cfg.BannerCallback == nil {
+		cfg.BannerCallback = func(gossh.ConnMetadata) string { return banner }
+	}
+
+	return cfg
+}
@@ -0,0 +1,95 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_banner(t *testing.T) {
+	signer, pub := newTestSigner(t)
+	var tests = []struct {
+		name     string
+		srv      *Server
+		expected string
+	}{
+		{name: "open-mode", srv: &Server{}, expected: "no authentication"},
+		{name: "open-mode-custom", srv: &Server{OpenBanner: "open\n"}, expected: "open\n"},
+		{name: "auth", srv: &Server{AuthorizedKeys: []ssh.PublicKey{pub}, Banner: "welcome\n"}, expected: "welcome\n"},
+		{name: "auth-no-banner", srv: &Server{AuthorizedKeys: []ssh.PublicKey{pub}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.srv.Shell = "sh"
+			banner := ""
+			cfg := clientConfigWithKey(signer)
+			cfg.BannerCallback = func(msg string) error {
+				banner = msg
+				return nil
+			}
+
+			session, _, cleanup := newTestSession(t, tt.srv.getServer(), cfg)
+			defer cleanup()
+
+			if err := session.Run("true"); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.expected == "" && banner != "" {
+				t.Errorf("unexpected banner %q", banner)
+			}
+
+			if !strings.Contains(banner, tt.expected) {
+				t.Errorf("got banner %q, expected %q", banner, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_LoadBanner(t *testing.T) {
+	if _, err := LoadBanner(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("a missing banner file was loaded")
+	}
+
+	notice := "Authorized use only.\nActivity is monitored.\n"
+	path := filepath.Join(t.TempDir(), "banner")
+	if err := ioutil.WriteFile(path, []byte(notice), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	banner, err := LoadBanner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, pub := newTestSigner(t)
+	s := &Server{Shell: "sh", AuthorizedKeys: []ssh.PublicKey{pub}, Banner: banner}
+	presented := ""
+	cfg := clientConfigWithKey(signer)
+	cfg.BannerCallback = func(msg string) error {
+		presented = msg
+		return nil
+	}
+
+	_, _, cleanup := newTestSession(t, s.getServer(), cfg)
+	defer cleanup()
+
+	if presented != notice {
+		t.Errorf("got banner %q, expected %q", presented, notice)
+	}
+}
+
+func Test_serverConfig_bannerCallback(t *testing.T) {
+	s := &Server{ServerConfigCallback: func(ctx ssh.Context) *gossh.ServerConfig {
+		return &gossh.ServerConfig{BannerCallback: func(gossh.ConnMetadata) string { return "custom" }}
+	}}
+
+	if banner := s.serverConfig(nil).BannerCallback(nil); banner != "custom" {
+		t.Errorf("the BannerCallback of ServerConfigCallback was overridden, got %q", banner)
+	}
+}
@@ -0,0 +1,62 @@
+package ssh
+
+import (
+	"fmt"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// LoadTrustedCAKeys loads the public keys of the CAs at path, one per line.
+// It will return nil if path doesn't exist.
+func LoadTrustedCAKeys(path string) ([]ssh.PublicKey, error) {
+	return LoadAuthorizedKeys(path)
+}
+
+func (srv *Server) isTrustedCA(key ssh.PublicKey) bool {
+	for _, ca := range srv.TrustedUserCAKeys {
+		if ssh.KeysEqual(key, ca) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkCertificate reports whether cert is a valid user certificate for user
+// signed by one of TrustedUserCAKeys, and why. Like OpenSSH, user must be one
+// of the principals of the certificate.
+func (srv *Server) checkCertificate(user string, cert *gossh.Certificate) (bool, string) {
+	if cert.CertType != gossh.UserCert {
+		return false, "certificate is not a user certificate"
+	}
+
+	if !srv.isTrustedCA(cert.SignatureKey) {
+		return false, "certificate is not signed by a trusted CA"
+	}
+
+	if len(cert.ValidPrincipals) == 0 {
+		return false, "certificate has no principals"
+	}
+
+	if !isPrincipal(user, cert.ValidPrincipals) {
+		return false, fmt.Sprintf("user %q is not a principal of the certificate", user)
+	}
+
+	checker := &gossh.CertChecker{}
+	if err := checker.CheckCert(user, cert); err != nil {
+		return false, err.Error()
+	}
+
+	return true, "certificate is signed by a trusted CA"
+}
+
+func isPrincipal(user string, principals []string) bool {
+	for _, p := range principals {
+		if p == user {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,168 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func newTestCertSigner(t *testing.T, ca gossh.Signer, cert *gossh.Certificate) gossh.Signer {
+	signer, pub := newTestSigner(t)
+	cert.Key = pub
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	certSigner, err := gossh.NewCertSigner(cert, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certSigner
+}
+
+func Test_certificateAuth(t *testing.T) {
+	ca, caPub := newTestSigner(t)
+	otherCA, _ := newTestSigner(t)
+	now := time.Now()
+	valid := func() *gossh.Certificate {
+		return &gossh.Certificate{
+			CertType:        gossh.UserCert,
+			ValidPrincipals: []string{"okteto"},
+			ValidAfter:      uint64(now.Add(-time.Hour).Unix()),
+			ValidBefore:     uint64(now.Add(time.Hour).Unix()),
+		}
+	}
+
+	var tests = []struct {
+		name     string
+		ca       gossh.Signer
+		modify   func(*gossh.Certificate)
+		accepted bool
+	}{
+		{name: "valid", ca: ca, accepted: true},
+		{name: "untrusted-ca", ca: otherCA},
+		{name: "expired", ca: ca, modify: func(c *gossh.Certificate) { c.ValidBefore = uint64(now.Add(-time.Minute).Unix()) }},
+		{name: "not-yet-valid", ca: ca, modify: func(c *gossh.Certificate) { c.ValidAfter = uint64(now.Add(time.Hour).Unix()) }},
+		{name: "wrong-principal", ca: ca, modify: func(c *gossh.Certificate) { c.ValidPrincipals = []string{"root"} }},
+		{name: "no-principals", ca: ca, modify: func(c *gossh.Certificate) { c.ValidPrincipals = nil }},
+		{name: "host-certificate", ca: ca, modify: func(c *gossh.Certificate) { c.CertType = gossh.HostCert }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := valid()
+			if tt.modify != nil {
+				tt.modify(cert)
+			}
+
+			s := &Server{Shell: "sh", TrustedUserCAKeys: []ssh.PublicKey{caPub}}
+			l := newLocalListener()
+			go serveOnce(s.getServer(), l)
+
+			cfg := clientConfigWithKey(newTestCertSigner(t, tt.ca, cert))
+			cfg.HostKeyCallback = gossh.InsecureIgnoreHostKey()
+			client, err := gossh.Dial("tcp", l.Addr().String(), cfg)
+			if !tt.accepted {
+				if err == nil {
+					client.Close()
+					t.Fatal("client was authenticated")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client.Close()
+		})
+	}
+}
+
+func Test_certificateAuth_principals(t *testing.T) {
+	ca, caPub := newTestSigner(t)
+	now := time.Now()
+	var tests = []struct {
+		name     string
+		user     string
+		accepted bool
+	}{
+		{name: "first-principal", user: "okteto", accepted: true},
+		{name: "second-principal", user: "deploy", accepted: true},
+		{name: "not-a-principal", user: "root"},
+		{name: "prefix-of-a-principal", user: "okt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &gossh.Certificate{
+				CertType:        gossh.UserCert,
+				ValidPrincipals: []string{"okteto", "deploy"},
+				ValidAfter:      uint64(now.Add(-time.Hour).Unix()),
+				ValidBefore:     uint64(now.Add(time.Hour).Unix()),
+			}
+
+			s := &Server{Shell: "sh", TrustedUserCAKeys: []ssh.PublicKey{caPub}}
+			logs := captureLogs(t)
+			l := newLocalListener()
+			go serveOnce(s.getServer(), l)
+
+			cfg := clientConfigWithKey(newTestCertSigner(t, ca, cert))
+			cfg.User = tt.user
+			cfg.HostKeyCallback = gossh.InsecureIgnoreHostKey()
+			client, err := gossh.Dial("tcp", l.Addr().String(), cfg)
+			if !tt.accepted {
+				if err == nil {
+					client.Close()
+					t.Fatal("client was authenticated")
+				}
+
+				waitForLog(t, logs, "is not a principal of the certificate")
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client.Close()
+		})
+	}
+}
+
+func Test_checkKey_caKeyIsNotAnAuthorizedKey(t *testing.T) {
+	_, caPub := newTestSigner(t)
+	s := &Server{TrustedUserCAKeys: []ssh.PublicKey{caPub}}
+	if ok, _ := s.CheckKey("okteto", caPub); ok {
+		t.Error("the CA key was accepted as a user key")
+	}
+}
+
+func Test_LoadTrustedCAKeys(t *testing.T) {
+	keys, err := LoadTrustedCAKeys(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || keys != nil {
+		t.Fatalf("got %v, %v for a missing file", keys, err)
+	}
+
+	_, caPub := newTestSigner(t)
+	path := filepath.Join(t.TempDir(), "trusted_ca")
+	if err := ioutil.WriteFile(path, gossh.MarshalAuthorizedKey(caPub), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err = LoadTrustedCAKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || !ssh.KeysEqual(keys[0], caPub) {
+		t.Errorf("got %v, expected the CA key", keys)
+	}
+}
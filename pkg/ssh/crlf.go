@@ -0,0 +1,45 @@
+package ssh
+
+import (
+	"bufio"
+	"io"
+)
+
+// crlfReader replaces CRLF line endings with LF
+type crlfReader struct {
+	r *bufio.Reader
+}
+
+func newCRLFReader(r io.Reader) io.Reader {
+	return &crlfReader{r: bufio.NewReader(r)}
+}
+
+func (c *crlfReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+
+			return 0, err
+		}
+
+		if b == '\r' {
+			if next, err := c.r.Peek(1); err == nil && next[0] == '\n' {
+				continue
+			}
+		}
+
+		p[n] = b
+		n++
+
+		// don't block for more input if some is ready to be returned
+		if c.r.Buffered() == 0 {
+			break
+		}
+	}
+
+	return n, nil
+}
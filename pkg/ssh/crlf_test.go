@@ -0,0 +1,63 @@
+package ssh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_crlfReader(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{input: "a\r\nb\r\n", expected: "a\nb\n"},
+		{input: "a\rb\n", expected: "a\rb\n"},
+		{input: "a\r", expected: "a\r"},
+		{input: "\r\n\r\n", expected: "\n\n"},
+	}
+
+	for _, tt := range tests {
+		// read one byte at a time to split CRLF across reads
+		got, err := ioutil.ReadAll(newCRLFReader(iotest.OneByteReader(strings.NewReader(tt.input))))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(got) != tt.expected {
+			t.Errorf("%q: got %q, expected %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func Test_handleNoTTY_normalizeCRLF(t *testing.T) {
+	var tests = []struct {
+		name      string
+		normalize bool
+		expected  string
+	}{
+		{name: "raw", expected: "a\r\nb\r\n"},
+		{name: "normalized", normalize: true, expected: "a\nb\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", NormalizeCRLF: tt.normalize}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			var stdout bytes.Buffer
+			session.Stdout = &stdout
+			session.Stdin = strings.NewReader("a\r\nb\r\n")
+			if err := session.Run("cat"); err != nil {
+				t.Fatal(err)
+			}
+
+			if stdout.String() != tt.expected {
+				t.Errorf("got %q, expected %q", stdout.String(), tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// deadlineConn bounds how long every read and write on a connection can
+// block, on top of the deadlines set by the ssh server, so clients that stall
+// in the middle of a transfer are dropped
+type deadlineConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// the deadlines set by the ssh server, and the ones of the read and write
+	// in progress, which the ssh server can't extend while they block
+	mu             sync.Mutex
+	readDeadline   time.Time
+	writeDeadline  time.Time
+	readTimeoutAt  time.Time
+	writeTimeoutAt time.Time
+}
+
+// Read returns a *readTimeoutError when it timed out because of readTimeout
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	if c.readTimeout <= 0 {
+		return c.Conn.Read(p)
+	}
+
+	c.mu.Lock()
+	c.readTimeoutAt = time.Now().Add(c.readTimeout)
+	err := c.Conn.SetReadDeadline(earliest(c.readDeadline, c.readTimeoutAt))
+	c.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	timedOut := earliest(c.readDeadline, c.readTimeoutAt).Equal(c.readTimeoutAt)
+	c.readTimeoutAt = time.Time{}
+	c.mu.Unlock()
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() && timedOut {
+		err = &readTimeoutError{err: netErr}
+	}
+
+	return n, err
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if c.writeTimeout <= 0 {
+		return c.Conn.Write(p)
+	}
+
+	c.mu.Lock()
+	c.writeTimeoutAt = time.Now().Add(c.writeTimeout)
+	err := c.Conn.SetWriteDeadline(earliest(c.writeDeadline, c.writeTimeoutAt))
+	c.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Write(p)
+	c.mu.Lock()
+	c.writeTimeoutAt = time.Time{}
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return c.SetWriteDeadline(t)
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(earliest(t, c.readTimeoutAt))
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return c.Conn.SetWriteDeadline(earliest(t, c.writeTimeoutAt))
+}
+
+// readTimeoutError is a read that timed out because of the ReadTimeout of
+// deadlineConn, as opposed to a deadline set by the ssh server
+type readTimeoutError struct {
+	err net.Error
+}
+
+func (e *readTimeoutError) Error() string {
+	return e.err.Error()
+}
+
+func (e *readTimeoutError) Timeout() bool {
+	return true
+}
+
+func (e *readTimeoutError) Temporary() bool {
+	return e.err.Temporary()
+}
+
+func (e *readTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// earliest returns the earliest of two deadlines, where zero means none
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+
+	return a
+}
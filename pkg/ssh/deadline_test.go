@@ -0,0 +1,75 @@
+package ssh
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_readTimeout(t *testing.T) {
+	var tests = []struct {
+		name        string
+		readTimeout time.Duration
+		closed      bool
+	}{
+		{name: "stalled-client", readTimeout: 200 * time.Millisecond, closed: true},
+		{name: "disabled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", ReadTimeout: tt.readTimeout}
+			l := newLocalListener()
+			go serveOnce(s.getServer(), l)
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer conn.Close()
+
+			r := bufio.NewReader(conn)
+			if _, err := r.ReadString('\n'); err != nil {
+				t.Fatal(err)
+			}
+
+			// the client stops in the middle of its version line
+			if _, err := conn.Write([]byte("SSH-2.0-")); err != nil {
+				t.Fatal(err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = r.ReadByte()
+			netErr, ok := err.(net.Error)
+			timedOut := ok && netErr.Timeout()
+			if closed := err != nil && !timedOut; closed != tt.closed {
+				t.Errorf("got connection closed %t, expected %t: %v", closed, tt.closed, err)
+			}
+		})
+	}
+}
+
+func Test_earliest(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Second)
+	var tests = []struct {
+		name     string
+		a, b     time.Time
+		expected time.Time
+	}{
+		{name: "a-zero", b: now, expected: now},
+		{name: "b-zero", a: now, expected: now},
+		{name: "a-earlier", a: now, b: later, expected: now},
+		{name: "b-earlier", a: later, b: now, expected: now},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := earliest(tt.a, tt.b); !got.Equal(tt.expected) {
+				t.Errorf("got %s, expected %s", got, tt.expected)
+			}
+		})
+	}
+}
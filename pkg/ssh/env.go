@@ -0,0 +1,126 @@
+package ssh
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+func envName(kv string) string {
+	if i := strings.Index(kv, "="); i >= 0 {
+		return kv[:i]
+	}
+
+	return kv
+}
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// splitMalformedEnv separates the KEY=VALUE entries of env with a valid
+// name from the malformed ones
+func splitMalformedEnv(env []string) ([]string, []string) {
+	valid := make([]string, 0, len(env))
+	var malformed []string
+	for _, kv := range env {
+		if !strings.Contains(kv, "=") || !envNameRegexp.MatchString(envName(kv)) {
+			malformed = append(malformed, kv)
+			continue
+		}
+
+		valid = append(valid, kv)
+	}
+
+	return valid, malformed
+}
+
+func hasEnv(env []string, name string) bool {
+	for _, kv := range env {
+		if envName(kv) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func envSize(env []string) int {
+	size := 0
+	for _, kv := range env {
+		size += len(kv)
+	}
+
+	return size
+}
+
+// loginEnv returns the HOME, USER, SHELL and PATH variables a login shell would
+// set, skipping the ones that are already defined in env.
+func loginEnv(env []string, shell string) []string {
+	defaults := []string{}
+	if u, err := user.Current(); err == nil {
+		if !hasEnv(env, "HOME") && u.HomeDir != "" {
+			defaults = append(defaults, fmt.Sprintf("HOME=%s", u.HomeDir))
+		}
+
+		if !hasEnv(env, "USER") && u.Username != "" {
+			defaults = append(defaults, fmt.Sprintf("USER=%s", u.Username))
+		}
+	}
+
+	if !hasEnv(env, "SHELL") && shell != "" {
+		if p, err := exec.LookPath(shell); err == nil {
+			shell = p
+		}
+
+		defaults = append(defaults, fmt.Sprintf("SHELL=%s", shell))
+	}
+
+	if !hasEnv(env, "PATH") {
+		defaults = append(defaults, fmt.Sprintf("PATH=%s", defaultPath))
+	}
+
+	return defaults
+}
+
+// redactEnv masks the values of the variables whose names match any of the
+// denylist patterns, so they can be logged safely.
+func redactEnv(env []string, denylist []string) []string {
+	redacted := make([]string, 0, len(env))
+	for _, kv := range env {
+		name := envName(kv)
+		if matchesAny(name, denylist) {
+			kv = fmt.Sprintf("%s=***", name)
+		}
+
+		redacted = append(redacted, kv)
+	}
+
+	return redacted
+}
+
+// filterEnv returns the variables of env whose names match any of the
+// allowlist patterns
+func filterEnv(env []string, allowlist []string) []string {
+	allowed := make([]string, 0, len(env))
+	for _, kv := range env {
+		if matchesAny(envName(kv), allowlist) {
+			allowed = append(allowed, kv)
+		}
+	}
+
+	return allowed
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, name); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,368 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func unsetEnv(t *testing.T, names ...string) {
+	for _, name := range names {
+		if v, ok := os.LookupEnv(name); ok {
+			os.Unsetenv(name)
+			t.Cleanup(func() { os.Setenv(name, v) })
+		}
+	}
+}
+
+func Test_loginEnv(t *testing.T) {
+	env := loginEnv([]string{"HOME=/custom"}, "sh")
+	if hasEnv(env, "HOME") {
+		t.Errorf("HOME was overridden: %v", env)
+	}
+
+	for _, name := range []string{"USER", "SHELL", "PATH"} {
+		if !hasEnv(env, name) {
+			t.Errorf("%s is missing: %v", name, env)
+		}
+	}
+}
+
+func Test_connectionHandler_loginEnv(t *testing.T) {
+	unsetEnv(t, "HOME", "USER", "SHELL")
+
+	s := &Server{Shell: "sh"}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Run(`echo "$HOME|$USER|$SHELL"`); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range strings.Split(strings.TrimSpace(stdout.String()), "|") {
+		if v == "" {
+			t.Errorf("variable %d is empty: %q", i, stdout.String())
+		}
+	}
+}
+
+func Test_redactEnv(t *testing.T) {
+	env := redactEnv([]string{"AWS_SECRET_ACCESS_KEY=secret", "GITHUB_TOKEN=token", "LANG=C"}, []string{"AWS_SECRET_ACCESS_KEY", "*_TOKEN"})
+	expected := []string{"AWS_SECRET_ACCESS_KEY=***", "GITHUB_TOKEN=***", "LANG=C"}
+	if strings.Join(env, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %v, expected %v", env, expected)
+	}
+}
+
+func Test_connectionHandler_envLogDenylist(t *testing.T) {
+	logs := captureLogs(t)
+
+	s := &Server{Shell: "sh", EnvLogDenylist: []string{"*_TOKEN"}}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Setenv("MY_TOKEN", "supersecret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(logs.String(), "supersecret") {
+		t.Errorf("denylisted value was logged:\n%s", logs.String())
+	}
+
+	if !strings.Contains(logs.String(), "MY_TOKEN=***") {
+		t.Errorf("denylisted variable wasn't logged as redacted:\n%s", logs.String())
+	}
+}
+
+func Test_connectionHandler_maxEnvSize(t *testing.T) {
+	var tests = []struct {
+		name     string
+		vars     int
+		rejected bool
+	}{
+		{name: "under-limit", vars: 2},
+		{name: "over-limit", vars: 50, rejected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", MaxEnvSize: 1024}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			for i := 0; i < tt.vars; i++ {
+				if err := session.Setenv(fmt.Sprintf("VAR_%d", i), strings.Repeat("x", 100)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var stderr bytes.Buffer
+			session.Stderr = &stderr
+			err := session.Run("true")
+			if !tt.rejected {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			exitErr, ok := err.(*gossh.ExitError)
+			if !ok || exitErr.ExitStatus() != ExitCodeRejected {
+				t.Fatalf("expected exit code %d, got %v", ExitCodeRejected, err)
+			}
+
+			if !strings.Contains(stderr.String(), "environment is larger") {
+				t.Errorf("bad message: %q", stderr.String())
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_disableServerEnv(t *testing.T) {
+	var tests = []struct {
+		name    string
+		disable bool
+	}{
+		{name: "pass-through"},
+		{name: "disabled", disable: true},
+	}
+
+	t.Setenv("OKTETO_TEST_SECRET", "supersecret")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", DisableServerEnv: tt.disable}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			var stdout bytes.Buffer
+			session.Stdout = &stdout
+			if err := session.Run(`echo "$OKTETO_TEST_SECRET|$PATH"`); err != nil {
+				t.Fatal(err)
+			}
+
+			values := strings.Split(strings.TrimSpace(stdout.String()), "|")
+			if leaked := values[0] == "supersecret"; leaked == tt.disable {
+				t.Errorf("got server variable %q with DisableServerEnv=%t", values[0], tt.disable)
+			}
+
+			if values[1] == "" {
+				t.Error("PATH is empty")
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_allowedEnv(t *testing.T) {
+	var tests = []struct {
+		name     string
+		allowed  []string
+		expected string
+	}{
+		{name: "nil-allows-everything", expected: "1|2|/tmp/evil.so"},
+		{name: "allowlist", allowed: []string{"ALLOWED", "LC_*"}, expected: "1||"},
+		{name: "glob", allowed: []string{"*ED", "OTHER"}, expected: "1|2|"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, "LD_PRELOAD")
+
+			s := &Server{Shell: "sh", AllowedEnv: tt.allowed}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			for name, value := range map[string]string{"ALLOWED": "1", "OTHER": "2", "LD_PRELOAD": "/tmp/evil.so"} {
+				if err := session.Setenv(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var stdout bytes.Buffer
+			session.Stdout = &stdout
+			if err := session.Run(`echo "$ALLOWED|$OTHER|$LD_PRELOAD"`); err != nil {
+				t.Fatal(err)
+			}
+
+			if out := strings.TrimSpace(stdout.String()); out != tt.expected {
+				t.Errorf("got %q, expected %q", out, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_envSanitizer(t *testing.T) {
+	// OKTETO_SCRATCH is added by the server for the session
+	s := &Server{Shell: "sh", ScratchDirBase: t.TempDir(), EnvSanitizer: func(env []string) []string {
+		var sanitized []string
+		for _, kv := range env {
+			if !strings.HasPrefix(kv, "SUDO_") && !strings.HasPrefix(kv, scratchDirEnv+"=") {
+				sanitized = append(sanitized, kv)
+			}
+		}
+
+		return append(sanitized, "SANITIZED=yes")
+	}}
+
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	for name, value := range map[string]string{"SUDO_USER": "root", "SUDO_UID": "0", "KEPT": "1"} {
+		if err := session.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Run(`echo "$SUDO_USER|$SUDO_UID|$OKTETO_SCRATCH|$KEPT|$SANITIZED"`); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := strings.TrimSpace(stdout.String()); out != "|||1|yes" {
+		t.Errorf("got %q, expected the sanitized environment", out)
+	}
+}
+
+func Test_splitMalformedEnv(t *testing.T) {
+	valid, malformed := splitMalformedEnv([]string{"GOOD=1", "_ALSO_GOOD=", "NOEQUALS", "=value", "BAD NAME=x", "1BAD=x"})
+	if strings.Join(valid, ",") != "GOOD=1,_ALSO_GOOD=" {
+		t.Errorf("got valid %v", valid)
+	}
+
+	if len(malformed) != 4 {
+		t.Errorf("got malformed %v", malformed)
+	}
+}
+
+// envSession is a session that only has a client environment
+type envSession struct {
+	ssh.Session
+	env []string
+}
+
+func (s envSession) Environ() []string {
+	return s.env
+}
+
+func (s envSession) RawCommand() string {
+	return ""
+}
+
+func Test_buildCmd_malformedEnvWithoutName(t *testing.T) {
+	logs := &syncBuffer{}
+	logger := log.New()
+	logger.SetOutput(logs)
+
+	srv := &Server{Shell: "sh", DisableServerEnv: true}
+	cmd := srv.buildCmd(log.NewEntry(logger), envSession{env: []string{"hunter2"}}, "sh")
+	if hasEnv(cmd.Env, "hunter2") {
+		t.Error("the entry without '=' wasn't dropped")
+	}
+
+	if strings.Contains(logs.String(), "hunter2") {
+		t.Errorf("the entry was logged:\n%s", logs.String())
+	}
+
+	if !strings.Contains(logs.String(), "dropped malformed environment entry of 7 bytes without '='") {
+		t.Errorf("the dropped entry wasn't logged:\n%s", logs.String())
+	}
+}
+
+func Test_connectionHandler_malformedEnv(t *testing.T) {
+	logs := captureLogs(t)
+
+	s := &Server{Shell: "sh"}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	for name, value := range map[string]string{"GOOD": "1", "BAD NAME": "2", "": "3"} {
+		if err := session.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Run("echo $GOOD; env | grep -c '^BAD NAME=\\|^=3' || true"); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := strings.Fields(stdout.String()); len(out) != 2 || out[0] != "1" || out[1] != "0" {
+		t.Errorf("got %q, expected GOOD to pass and the malformed entries to be dropped", stdout.String())
+	}
+
+	for _, name := range []string{`"BAD NAME"`, `""`} {
+		if !strings.Contains(logs.String(), "dropped malformed environment variable "+strings.ReplaceAll(name, `"`, `\"`)) {
+			t.Errorf("%s wasn't logged:\n%s", name, logs.String())
+		}
+	}
+}
+
+func Test_connectionHandler_maxEnvVars(t *testing.T) {
+	var tests = []struct {
+		name     string
+		drop     bool
+		rejected bool
+	}{
+		{name: "rejected", rejected: true},
+		{name: "dropped", drop: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+
+			s := &Server{Shell: "sh", MaxEnvVars: 3, DropExtraEnvVars: tt.drop}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			for i := 0; i < 5; i++ {
+				if err := session.Setenv(fmt.Sprintf("VAR_%d", i), "x"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var stdout, stderr bytes.Buffer
+			session.Stdout = &stdout
+			session.Stderr = &stderr
+			err := session.Run("env | grep -c '^VAR_'")
+			if tt.rejected {
+				exitErr, ok := err.(*gossh.ExitError)
+				if !ok || exitErr.ExitStatus() != ExitCodeRejected {
+					t.Fatalf("expected exit code %d, got %v", ExitCodeRejected, err)
+				}
+
+				if !strings.Contains(stderr.String(), "maximum of 3 environment variables") {
+					t.Errorf("bad message: %q", stderr.String())
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if out := strings.TrimSpace(stdout.String()); out != "3" {
+				t.Errorf("the command got %s variables, expected 3", out)
+			}
+
+			if !strings.Contains(logs.String(), "dropped 2 environment variables") {
+				t.Errorf("the dropped variables weren't logged:\n%s", logs.String())
+			}
+		})
+	}
+}
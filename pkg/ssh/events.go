@@ -0,0 +1,102 @@
+package ssh
+
+import (
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	eventBufferSize = 256
+
+	// EventSessionStart is emitted when a session is opened
+	EventSessionStart = "session.start"
+	// EventSessionEnd is emitted when a session is closed
+	EventSessionEnd = "session.end"
+	// EventAuth is emitted for every public key authentication attempt
+	EventAuth = "auth"
+	// EventExec is emitted when the command of a session is started
+	EventExec = "exec"
+)
+
+// Event is a session lifecycle record published to the event socket
+type Event struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	SessionID  string    `json:"session.id,omitempty"`
+	User       string    `json:"user,omitempty"`
+	RemoteAddr string    `json:"remote.address,omitempty"`
+	Command    string    `json:"command,omitempty"`
+	Success    *bool     `json:"success,omitempty"`
+}
+
+// eventEmitter writes events as JSON lines to a unix socket. Events are
+// buffered, and dropped when the buffer is full or the socket is unavailable.
+type eventEmitter struct {
+	path    string
+	ch      chan Event
+	dropped uint64
+}
+
+func newEventEmitter(path string) *eventEmitter {
+	e := &eventEmitter{
+		path: path,
+		ch:   make(chan Event, eventBufferSize),
+	}
+
+	go e.run()
+	return e
+}
+
+func (e *eventEmitter) emit(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	select {
+	case e.ch <- ev:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// Dropped returns the number of events that couldn't be delivered
+func (e *eventEmitter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+func (e *eventEmitter) run() {
+	var conn net.Conn
+	var enc *json.Encoder
+	for ev := range e.ch {
+		if conn == nil {
+			c, err := net.Dial("unix", e.path)
+			if err != nil {
+				log.WithError(err).Debugf("failed to connect to event socket %s", e.path)
+				atomic.AddUint64(&e.dropped, 1)
+				continue
+			}
+
+			conn = c
+			enc = json.NewEncoder(conn)
+		}
+
+		if err := enc.Encode(ev); err != nil {
+			log.WithError(err).Debugf("failed to write to event socket %s", e.path)
+			atomic.AddUint64(&e.dropped, 1)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+func (srv *Server) emit(ev Event) {
+	if srv.events == nil {
+		return
+	}
+
+	srv.events.emit(ev)
+}
@@ -0,0 +1,63 @@
+package ssh
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_eventSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	s := &Server{Shell: "sh", EventSocketPath: path}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run("echo hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	l.(*net.UnixListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	var ev Event
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&ev); err != nil {
+		t.Fatal(err)
+	}
+
+	if ev.Type != EventSessionStart {
+		t.Errorf("got event %s, expected %s", ev.Type, EventSessionStart)
+	}
+
+	if ev.SessionID == "" {
+		t.Error("event is missing the session id")
+	}
+}
+
+func Test_eventEmitter_dropped(t *testing.T) {
+	e := newEventEmitter(filepath.Join(t.TempDir(), "missing.sock"))
+	e.emit(Event{Type: EventSessionStart})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for e.Dropped() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("undeliverable event wasn't counted as dropped")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
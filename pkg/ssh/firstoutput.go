@@ -0,0 +1,62 @@
+package ssh
+
+import (
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// outputWatch tracks whether a command has written anything to its stdout or
+// stderr
+type outputWatch struct {
+	once sync.Once
+	seen chan struct{}
+}
+
+func newOutputWatch() *outputWatch {
+	return &outputWatch{seen: make(chan struct{})}
+}
+
+func (o *outputWatch) wrap(w io.Writer) io.Writer {
+	return &watchedWriter{w: w, watch: o}
+}
+
+type watchedWriter struct {
+	w     io.Writer
+	watch *outputWatch
+}
+
+func (w *watchedWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.watch.once.Do(func() { close(w.watch.seen) })
+	}
+
+	return w.w.Write(p)
+}
+
+// watchFirstOutput warns when cmd produces no output within
+// FirstOutputTimeout, and kills it when KillOnFirstOutputTimeout is set. The
+// returned function stops the watch.
+func (srv *Server) watchFirstOutput(logger *log.Entry, cmd *exec.Cmd, watch *outputWatch) func() {
+	done := make(chan struct{})
+	go func() {
+		timer := time.NewTimer(srv.FirstOutputTimeout)
+		defer timer.Stop()
+		select {
+		case <-watch.seen:
+		case <-done:
+		case <-timer.C:
+			logger.Warningf("command '%s' didn't produce any output in %s", srv.redact(cmd.String()), srv.FirstOutputTimeout)
+			if srv.KillOnFirstOutputTimeout {
+				if err := terminateTracked(logger, cmd, srv.KillGrace); err != nil {
+					logger.WithError(err).Errorf("failed to terminate command '%s'", srv.redact(cmd.String()))
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,57 @@
+package ssh
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_connectionHandler_firstOutputTimeout(t *testing.T) {
+	var tests = []struct {
+		name    string
+		command string
+		warning bool
+	}{
+		{name: "delayed-output", command: "sleep 0.5; echo done", warning: true},
+		{name: "immediate-output", command: "echo done; sleep 0.5"},
+		{name: "stderr-output", command: "echo done >&2; sleep 0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+
+			s := &Server{Shell: "sh", FirstOutputTimeout: 100 * time.Millisecond}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			if err := session.Run(tt.command); err != nil {
+				t.Fatal(err)
+			}
+
+			warned := strings.Contains(logs.String(), "didn't produce any output")
+			if warned != tt.warning {
+				t.Errorf("got warning %t, expected %t:\n%s", warned, tt.warning, logs.String())
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_killOnFirstOutputTimeout(t *testing.T) {
+	s := &Server{Shell: "sh", FirstOutputTimeout: 100 * time.Millisecond, KillOnFirstOutputTimeout: true}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	start := time.Now()
+	err := session.Run("sleep 5")
+	exitErr, ok := err.(*gossh.ExitError)
+	if !ok || exitErr.ExitStatus() != 137 {
+		t.Fatalf("expected exit code 137, got %v", err)
+	}
+
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("command wasn't killed, it ran for %s", time.Since(start))
+	}
+}
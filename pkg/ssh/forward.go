@@ -0,0 +1,299 @@
+package ssh
+
+import (
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// The directions logged as forward.direction
+const (
+	forwardLocal   = "local"
+	forwardReverse = "reverse"
+)
+
+// ForwardStats counts the port forwards of the server
+type ForwardStats struct {
+	// Local is the number of local forwards established since the start
+	Local uint64 `json:"local"`
+	// Reverse is the number of reverse forwards established since the start
+	Reverse uint64 `json:"reverse"`
+	// Active is the number of forwards currently established
+	Active int64 `json:"active"`
+}
+
+// ForwardStats returns the port forward counters of the server
+func (srv *Server) ForwardStats() ForwardStats {
+	return ForwardStats{
+		Local:   atomic.LoadUint64(&srv.localForwards),
+		Reverse: atomic.LoadUint64(&srv.reverseForwards),
+		Active:  atomic.LoadInt64(&srv.activeForwards),
+	}
+}
+
+func (srv *Server) forwardLogger(ctx ssh.Context, direction, host string, port uint32) *log.Entry {
+	return log.WithFields(srv.logFields(log.Fields{
+		"connection.id":     connectionID(ctx),
+		"user":              ctx.User(),
+		"forward.direction": direction,
+		"forward.host":      host,
+		"forward.port":      port,
+	}))
+}
+
+// allowForward reports whether the connection of ctx can forward to or from
+// host and port, and logs why it can't
+func (srv *Server) allowForward(ctx ssh.Context, direction, host string, port uint32) bool {
+	if sftpOnly, _ := ctx.Value(sftpOnlyKey).(bool); sftpOnly {
+		srv.forwardLogger(ctx, direction, host, port).Info("forward denied, the key is only allowed to use sftp")
+		return false
+	}
+
+	if !srv.forwardAllowed(host, port) {
+		srv.forwardLogger(ctx, direction, host, port).Info("forward denied, not in the forward allowlist")
+		return false
+	}
+
+	return true
+}
+
+// forwardLimiter returns the rate limiter shared by the forwards of the
+// connection of ctx, nil when MaxForwardBytesPerSec is zero
+func (srv *Server) forwardLimiter(ctx ssh.Context) *rateLimiter {
+	if srv.MaxForwardBytesPerSec <= 0 {
+		return nil
+	}
+
+	id := connectionID(ctx)
+	limiter, loaded := srv.forwardLimiters.LoadOrStore(id, newRateLimiter(srv.MaxForwardBytesPerSec))
+	if !loaded {
+		go func() {
+			<-ctx.Done()
+			srv.forwardLimiters.Delete(id)
+		}()
+	}
+
+	return limiter.(*rateLimiter)
+}
+
+// forwardEstablished logs and counts a forward, and returns the function
+// that logs its teardown
+func (srv *Server) forwardEstablished(ctx ssh.Context, direction, host string, port uint32) func() {
+	if direction == forwardLocal {
+		atomic.AddUint64(&srv.localForwards, 1)
+	} else {
+		atomic.AddUint64(&srv.reverseForwards, 1)
+	}
+
+	atomic.AddInt64(&srv.activeForwards, 1)
+	logger := srv.forwardLogger(ctx, direction, host, port)
+	logger.Info("forward established")
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&srv.activeForwards, -1)
+			logger.Info("forward closed")
+		})
+	}
+}
+
+// directTCPIPHandler serves local forwards with ssh.DirectTCPIPHandler,
+// logging when the forwarded channel is opened and closed, and rate limiting
+// it
+func (srv *Server) directTCPIPHandler(server *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+	var d struct {
+		DestAddr   string
+		DestPort   uint32
+		OriginAddr string
+		OriginPort uint32
+	}
+
+	if err := gossh.Unmarshal(newChan.ExtraData(), &d); err == nil {
+		newChan = &wrappedNewChannel{NewChannel: newChan, wrap: func(ch gossh.Channel) gossh.Channel {
+			if limiter := srv.forwardLimiter(ctx); limiter != nil {
+				ch = &limitedChannel{Channel: ch, limiter: limiter}
+			}
+
+			return &trackedChannel{Channel: ch, onClose: srv.forwardEstablished(ctx, forwardLocal, d.DestAddr, d.DestPort)}
+		}}
+	}
+
+	ssh.DirectTCPIPHandler(server, conn, newChan, ctx)
+}
+
+// wrappedNewChannel wraps the channel when it's accepted
+type wrappedNewChannel struct {
+	gossh.NewChannel
+	wrap func(gossh.Channel) gossh.Channel
+}
+
+func (c *wrappedNewChannel) Accept() (gossh.Channel, <-chan *gossh.Request, error) {
+	ch, reqs, err := c.NewChannel.Accept()
+	if err != nil {
+		return ch, reqs, err
+	}
+
+	return c.wrap(ch), reqs, nil
+}
+
+// trackedChannel calls onClose when the channel is first closed
+type trackedChannel struct {
+	gossh.Channel
+	onClose func()
+}
+
+func (c *trackedChannel) Close() error {
+	c.onClose()
+	return c.Channel.Close()
+}
+
+// handleReverseForward serves the tcpip-forward and cancel-tcpip-forward
+// requests like ssh.ForwardedTCPHandler, logging when the forwards are bound
+// and closed, and rate limiting their channels
+func (srv *Server) handleReverseForward(ctx ssh.Context, server *ssh.Server, req *gossh.Request) (bool, []byte) {
+	conn, ok := ctx.Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+	if !ok {
+		return false, nil
+	}
+
+	var r struct {
+		BindAddr string
+		BindPort uint32
+	}
+
+	if err := gossh.Unmarshal(req.Payload, &r); err != nil {
+		return false, nil
+	}
+
+	switch req.Type {
+	case "tcpip-forward":
+		if server.ReversePortForwardingCallback == nil || !server.ReversePortForwardingCallback(ctx, r.BindAddr, r.BindPort) {
+			return false, []byte("port forwarding is disabled")
+		}
+
+		ln, err := net.Listen("tcp", net.JoinHostPort(r.BindAddr, strconv.FormatUint(uint64(r.BindPort), 10)))
+		if err != nil {
+			srv.forwardLogger(ctx, forwardReverse, r.BindAddr, r.BindPort).WithError(err).Info("failed to bind the forward")
+			return false, nil
+		}
+
+		// the port is allocated by the server when the client asks for 0
+		port := uint32(ln.Addr().(*net.TCPAddr).Port)
+		key := reverseForwardKey(ctx, r.BindAddr, port)
+		srv.reverseListeners.Store(key, ln)
+		closed := srv.forwardEstablished(ctx, forwardReverse, r.BindAddr, port)
+		go func() {
+			<-ctx.Done()
+			ln.Close()
+		}()
+
+		go func() {
+			defer closed()
+			defer srv.reverseListeners.CompareAndDelete(key, ln)
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+
+				go srv.forwardReverseConn(ctx, conn, c, r.BindAddr, port)
+			}
+		}()
+
+		return true, gossh.Marshal(struct{ BindPort uint32 }{port})
+	case "cancel-tcpip-forward":
+		if ln, ok := srv.reverseListeners.LoadAndDelete(reverseForwardKey(ctx, r.BindAddr, r.BindPort)); ok {
+			ln.(net.Listener).Close()
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// forwardReverseConn forwards c, accepted by the listener of a reverse
+// forward, to the client through a forwarded-tcpip channel
+func (srv *Server) forwardReverseConn(ctx ssh.Context, conn *gossh.ServerConn, c net.Conn, bindAddr string, port uint32) {
+	origin := c.RemoteAddr().(*net.TCPAddr)
+	payload := gossh.Marshal(struct {
+		DestAddr   string
+		DestPort   uint32
+		OriginAddr string
+		OriginPort uint32
+	}{bindAddr, port, origin.IP.String(), uint32(origin.Port)})
+
+	ch, reqs, err := conn.OpenChannel("forwarded-tcpip", payload)
+	if err != nil {
+		srv.forwardLogger(ctx, forwardReverse, bindAddr, port).WithError(err).Debug("failed to open the forwarded channel")
+		c.Close()
+		return
+	}
+
+	go gossh.DiscardRequests(reqs)
+	if limiter := srv.forwardLimiter(ctx); limiter != nil {
+		ch = &limitedChannel{Channel: ch, limiter: limiter}
+	}
+
+	go func() {
+		defer ch.Close()
+		defer c.Close()
+		io.Copy(ch, c)
+	}()
+
+	go func() {
+		defer ch.Close()
+		defer c.Close()
+		io.Copy(c, ch)
+	}()
+}
+
+func reverseForwardKey(ctx ssh.Context, host string, port uint32) string {
+	return connectionID(ctx) + " " + net.JoinHostPort(host, strconv.FormatUint(uint64(port), 10))
+}
+
+// forwardAllowed reports whether forwarding to, or binding, host:port is
+// allowed by ForwardAllowlist
+func (srv *Server) forwardAllowed(host string, port uint32) bool {
+	if srv.ForwardAllowlist == nil {
+		return true
+	}
+
+	for _, entry := range srv.ForwardAllowlist {
+		if matchesForward(entry, host, port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesForward reports whether host:port matches entry, which is either
+// host:port, where host can be a CIDR and both can be glob patterns, or a
+// CIDR or host matching every port
+func matchesForward(entry, host string, port uint32) bool {
+	entryHost, entryPort, err := net.SplitHostPort(entry)
+	if err != nil {
+		entryHost, entryPort = entry, "*"
+	}
+
+	if ok, _ := path.Match(entryPort, strconv.FormatUint(uint64(port), 10)); !ok {
+		return false
+	}
+
+	if _, cidr, err := net.ParseCIDR(entryHost); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && cidr.Contains(ip)
+	}
+
+	ok, _ := path.Match(entryHost, host)
+	return ok
+}
@@ -0,0 +1,343 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_matchesForward(t *testing.T) {
+	var tests = []struct {
+		entry    string
+		host     string
+		port     uint32
+		expected bool
+	}{
+		{entry: "db:5432", host: "db", port: 5432, expected: true},
+		{entry: "db:5432", host: "db", port: 5433},
+		{entry: "db:5432", host: "cache", port: 5432},
+		{entry: "*.svc.cluster.local:*", host: "api.svc.cluster.local", port: 8080, expected: true},
+		{entry: "*.svc.cluster.local:*", host: "api.example.com", port: 8080},
+		{entry: "10.0.0.0/8", host: "10.1.2.3", port: 22, expected: true},
+		{entry: "10.0.0.0/8", host: "192.168.1.1", port: 22},
+		{entry: "10.0.0.0/8", host: "db", port: 22},
+		{entry: "10.0.0.0/8:5432", host: "10.1.2.3", port: 5432, expected: true},
+		{entry: "10.0.0.0/8:5432", host: "10.1.2.3", port: 22},
+		{entry: "[fd00::/8]:80", host: "fd00::1", port: 80, expected: true},
+		{entry: "localhost", host: "localhost", port: 3000, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s-%s:%d", tt.entry, tt.host, tt.port), func(t *testing.T) {
+			if got := matchesForward(tt.entry, tt.host, tt.port); got != tt.expected {
+				t.Errorf("got %t, expected %t", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_getServer_forwardAllowlist(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+
+			c.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(target.Addr().String())
+	bind := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	var tests = []struct {
+		name      string
+		allowlist []string
+		allowed   bool
+	}{
+		{name: "nil", allowed: true},
+		{name: "matching", allowlist: []string{"db:5432", "127.0.0.1:" + port, bind}, allowed: true},
+		{name: "matching-cidr", allowlist: []string{"127.0.0.0/8"}, allowed: true},
+		{name: "other-port", allowlist: []string{"127.0.0.1:1"}},
+		{name: "other-cidr", allowlist: []string{"10.0.0.0/8"}},
+		{name: "empty", allowlist: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", ForwardAllowlist: tt.allowlist}
+			_, client, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			c, err := client.Dial("tcp", target.Addr().String())
+			if err == nil {
+				c.Close()
+			}
+
+			if tt.allowed && err != nil {
+				t.Errorf("local forward was refused: %v", err)
+			} else if !tt.allowed && err == nil {
+				t.Error("local forward was accepted")
+			}
+
+			l, err := client.Listen("tcp", bind)
+			if err == nil {
+				l.Close()
+			}
+
+			if tt.allowed && err != nil {
+				t.Errorf("reverse bind was refused: %v", err)
+			} else if !tt.allowed && err == nil {
+				t.Error("reverse bind was accepted")
+			}
+		})
+	}
+}
+
+func Test_getServer_sftpOnlyKeyForwards(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+
+			c.Close()
+		}
+	}()
+
+	var tests = []struct {
+		name     string
+		sftpOnly bool
+	}{
+		{name: "sftp-only", sftpOnly: true},
+		{name: "full-access"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, pub := newTestSigner(t)
+			s := &Server{Shell: "sh", AuthorizedKeys: []ssh.PublicKey{pub}}
+			if tt.sftpOnly {
+				s.SFTPOnlyKeys = []ssh.PublicKey{pub}
+			}
+
+			_, client, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+			defer cleanup()
+
+			c, err := client.Dial("tcp", target.Addr().String())
+			if err == nil {
+				c.Close()
+			}
+
+			if tt.sftpOnly != (err != nil) {
+				t.Errorf("got local forward error %v with an sftp-only key %t", err, tt.sftpOnly)
+			}
+
+			l, err := client.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", freePort(t)))
+			if err == nil {
+				l.Close()
+			}
+
+			if tt.sftpOnly != (err != nil) {
+				t.Errorf("got reverse forward error %v with an sftp-only key %t", err, tt.sftpOnly)
+			}
+		})
+	}
+}
+
+func Test_forwardRecords(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+
+			c.Close()
+		}
+	}()
+
+	logs := captureLogs(t)
+	formatter := log.StandardLogger().Formatter
+	log.SetFormatter(&log.JSONFormatter{})
+	defer log.SetFormatter(formatter)
+
+	s := &Server{Shell: "sh"}
+	_, client, cleanup := newTestSession(t, s.getServer(), &gossh.ClientConfig{User: "okteto"})
+	defer cleanup()
+
+	c, err := client.Dial("tcp", target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	waitForLog(t, logs, "forward closed")
+
+	host, port, _ := net.SplitHostPort(target.Addr().String())
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("%s: %s", err, line)
+		}
+
+		if msg := record["msg"]; msg == "forward established" || msg == "forward closed" {
+			records = append(records, record)
+		}
+	}
+
+	if len(records) != 2 || records[0]["msg"] != "forward established" {
+		t.Fatalf("expected a forward established and a forward closed record:\n%s", logs.String())
+	}
+
+	for _, record := range records {
+		if record["forward.direction"] != forwardLocal || record["forward.host"] != host || fmt.Sprint(record["forward.port"]) != port {
+			t.Errorf("bad forward fields: %v", record)
+		}
+
+		if record["user"] != "okteto" || record["connection.id"] == "" || record["connection.id"] == nil {
+			t.Errorf("bad connection fields: %v", record)
+		}
+	}
+
+	stats := s.ForwardStats()
+	if stats.Local != 1 || stats.Reverse != 0 || stats.Active != 0 {
+		t.Errorf("got stats %+v", stats)
+	}
+
+	l, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForLog(t, logs, `"forward.direction":"reverse"`)
+	if stats := s.ForwardStats(); stats.Reverse != 1 || stats.Active != 1 {
+		t.Errorf("got stats %+v with a reverse forward", stats)
+	}
+
+	rec := httptest.NewRecorder()
+	s.healthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/statusz", nil))
+	var st status
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Forwards != (ForwardStats{Local: 1, Reverse: 1, Active: 1}) {
+		t.Errorf("got forwards %+v on /statusz", st.Forwards)
+	}
+
+	l.Close()
+}
+
+func Test_maxForwardBytesPerSec(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 3*1024)
+	var tests = []struct {
+		name    string
+		limit   int64
+		reverse bool
+		min     time.Duration
+		max     time.Duration
+	}{
+		{name: "local-unlimited", max: time.Second},
+		{name: "local-limited", limit: 16 * 1024, min: 1500 * time.Millisecond, max: 4 * time.Second},
+		{name: "reverse-unlimited", reverse: true, max: time.Second},
+		{name: "reverse-limited", limit: 16 * 1024, reverse: true, min: 1500 * time.Millisecond, max: 4 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", MaxForwardBytesPerSec: tt.limit}
+			_, client, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			// the payload is sent through the forward to the other end, which
+			// replies once it's read all of it
+			var l net.Listener
+			var err error
+			if tt.reverse {
+				l, err = client.Listen("tcp", "127.0.0.1:0")
+			} else {
+				l, err = net.Listen("tcp", "127.0.0.1:0")
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer l.Close()
+			go func() {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+
+				defer c.Close()
+				if _, err := io.ReadFull(c, make([]byte, len(payload))); err == nil {
+					c.Write([]byte("done"))
+				}
+			}()
+
+			var c net.Conn
+			if tt.reverse {
+				c, err = net.Dial("tcp", l.Addr().String())
+			} else {
+				c, err = client.Dial("tcp", l.Addr().String())
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer c.Close()
+			start := time.Now()
+			if _, err := c.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+
+			reply := make([]byte, 4)
+			if _, err := io.ReadFull(c, reply); err != nil {
+				t.Fatal(err)
+			}
+
+			elapsed := time.Since(start)
+			if string(reply) != "done" {
+				t.Fatalf("got reply %q", reply)
+			}
+
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("forwarded %d bytes in %s, expected between %s and %s", len(payload), elapsed, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// accepting reports whether the SSH listener is accepting connections
+func (srv *Server) accepting() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.server != nil && !srv.shuttingDown
+}
+
+// status is the body of /statusz
+type status struct {
+	Accepting           bool              `json:"accepting"`
+	Ready               bool              `json:"ready"`
+	Sessions            int               `json:"sessions"`
+	Rejections          map[string]uint64 `json:"rejections"`
+	HostKeyFingerprints []string          `json:"host_key_fingerprints"`
+	Forwards            ForwardStats      `json:"forwards"`
+}
+
+// healthHandler serves /healthz, which is OK while the SSH listener accepts
+// connections, /readyz, which also requires Ready, and /statusz, which
+// describes the load and the host keys of the server in JSON
+func (srv *Server) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, srv.accepting())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, srv.accepting() && srv.Ready())
+	})
+	mux.HandleFunc("/statusz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status{
+			Accepting:           srv.accepting(),
+			Ready:               srv.Ready(),
+			Sessions:            len(srv.ActiveSessions()),
+			Rejections:          srv.Rejections(),
+			HostKeyFingerprints: srv.HostKeyFingerprints(),
+			Forwards:            srv.ForwardStats(),
+		})
+	})
+
+	return mux
+}
+
+func writeHealth(w http.ResponseWriter, ok bool) {
+	if !ok {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// listenHealth starts the health endpoint on HealthPort
+func (srv *Server) listenHealth() error {
+	l, err := net.Listen("tcp", net.JoinHostPort(srv.BindAddress, strconv.Itoa(srv.HealthPort)))
+	if err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	srv.healthListener = l
+	srv.mu.Unlock()
+
+	go func() {
+		if err := http.Serve(l, srv.healthHandler()); err != nil && !srv.draining() {
+			log.WithError(err).Error("health endpoint stopped")
+		}
+	}()
+
+	return nil
+}
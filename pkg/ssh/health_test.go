@@ -0,0 +1,168 @@
+package ssh
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func healthStatus(t *testing.T, port int, path string) int {
+	resp, err := http.Get("http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func Test_healthEndpoint(t *testing.T) {
+	s := &Server{Shell: "sh", HealthPort: freePort(t), ReadinessCommand: "false"}
+	startServer(t, s)
+	defer s.Shutdown(context.Background())
+
+	code := 0
+	for i := 0; i < 50 && code != http.StatusOK; i++ {
+		if resp, err := http.Get("http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(s.HealthPort)) + "/healthz"); err == nil {
+			code = resp.StatusCode
+			resp.Body.Close()
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if code != http.StatusOK {
+		t.Fatalf("/healthz returned %d", code)
+	}
+
+	if code := healthStatus(t, s.HealthPort, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz returned %d while the readiness command fails", code)
+	}
+
+	s.Shutdown(context.Background())
+	if conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(s.HealthPort))); err == nil {
+		conn.Close()
+		t.Error("health endpoint is still listening after Shutdown")
+	}
+}
+
+func Test_healthEndpoint_draining(t *testing.T) {
+	s := &Server{Shell: "sh", HealthPort: freePort(t)}
+	addr := startServer(t, s)
+
+	session, _, cleanup := newClientSession(t, addr, nil)
+	defer cleanup()
+
+	if err := session.Start("sleep 30"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50 && len(s.ActiveSessions()) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+
+	for i := 0; i < 50 && !s.draining(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code := healthStatus(t, s.HealthPort, "/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz returned %d while shutting down", code)
+	}
+
+	cancel()
+	<-shutdown
+}
+
+func Test_healthEndpoint_disabled(t *testing.T) {
+	port := freePort(t)
+	s := &Server{Shell: "sh"}
+	startServer(t, s)
+	defer s.Shutdown(context.Background())
+
+	if conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err == nil {
+		conn.Close()
+		t.Error("health endpoint is listening without HealthPort")
+	}
+}
+
+func Test_healthEndpoint_status(t *testing.T) {
+	s := &Server{Shell: "sh", HealthPort: freePort(t), LoadShedFunc: func() bool { return true }}
+	addr := startServer(t, s)
+	defer s.Shutdown(context.Background())
+
+	session, _, cleanup := newClientSession(t, addr, nil)
+	defer cleanup()
+
+	if err := session.Run("true"); err == nil {
+		t.Fatal("the session wasn't shed")
+	}
+
+	var st status
+	for i := 0; i < 50; i++ {
+		resp, err := http.Get("http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(s.HealthPort)) + "/statusz")
+		if err == nil {
+			err = json.NewDecoder(resp.Body).Decode(&st)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			break
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if !st.Accepting {
+		t.Errorf("got %+v, expected the server to be accepting", st)
+	}
+
+	expected := map[string]uint64{RejectCapacity: 0, RejectLoadShed: 1, RejectDrain: 0, RejectUnauthorized: 0}
+	for reason, n := range expected {
+		if got, ok := st.Rejections[reason]; !ok || got != n {
+			t.Errorf("got %d %s rejections, expected %d: %+v", got, reason, n, st)
+		}
+	}
+
+	fingerprints := s.HostKeyFingerprints()
+	if len(fingerprints) == 0 || strings.Join(st.HostKeyFingerprints, ",") != strings.Join(fingerprints, ",") {
+		t.Errorf("got host key fingerprints %v, expected %v", st.HostKeyFingerprints, fingerprints)
+	}
+}
+
+func Test_healthEndpoint_statusFields(t *testing.T) {
+	s := &Server{Shell: "sh"}
+	rec := httptest.NewRecorder()
+	s.healthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/statusz", nil))
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range []string{"accepting", "ready", "sessions", "rejections", "host_key_fingerprints", "forwards"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("%s is missing from /statusz: %s", field, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const hostKeyPollInterval = 100 * time.Millisecond
+
+// LoadHostKey loads the PEM encoded private key at path. The key is decrypted
+// with passphrase when it's not empty.
+func LoadHostKey(path, passphrase string) (gossh.Signer, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := parseHostKey(pemBytes, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load host key %s: %w", path, err)
+	}
+
+	return signer, nil
+}
+
+func parseHostKey(pemBytes []byte, passphrase string) (gossh.Signer, error) {
+	if passphrase == "" {
+		signer, err := gossh.ParsePrivateKey(pemBytes)
+		var missing *gossh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, errors.New("the key is encrypted and no passphrase was provided")
+		}
+
+		return signer, err
+	}
+
+	signer, err := gossh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, errors.New("wrong passphrase")
+	}
+
+	return signer, err
+}
+
+func (srv *Server) loadHostKeys() error {
+	signers := []gossh.Signer{}
+	for i, key := range srv.HostKeys {
+		signer, err := parseHostKey(key, "")
+		if err != nil {
+			return fmt.Errorf("failed to load host key %d: %w", i, err)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if srv.HostKeyPath != "" || len(signers) == 0 {
+		var signer gossh.Signer
+		var err error
+		switch {
+		case srv.HostKeyPath == "":
+			signer, err = parseHostKey([]byte(hostKeyBytes), "")
+		case srv.HostKeyWait > 0 || srv.HostKeyPassphrase != "":
+			// the key is provided by someone else, never generate it
+			signer, err = srv.waitForHostKey()
+		default:
+			signer, err = srv.loadOrGenerateHostKey()
+		}
+
+		if err != nil {
+			return err
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		return errors.New("no host key was loaded")
+	}
+
+	srv.hostSigners = signers
+	for _, fingerprint := range srv.HostKeyFingerprints() {
+		log.Infof("host key fingerprint: %s", fingerprint)
+	}
+
+	return nil
+}
+
+// loadOrGenerateHostKey loads or generates the key at HostKeyPath. If that
+// fails, the embedded key is only used with HostKeyFallbackToEmbedded.
+func (srv *Server) loadOrGenerateHostKey() (gossh.Signer, error) {
+	pemBytes, err := LoadOrGenerateHostKey(srv.HostKeyPath)
+	if err == nil {
+		return parseHostKey(pemBytes, "")
+	}
+
+	if !srv.HostKeyFallbackToEmbedded {
+		return nil, fmt.Errorf("failed to load or generate the host key %s: %w. Make its directory writable, provide the key or enable the fallback to the embedded key", srv.HostKeyPath, err)
+	}
+
+	log.WithError(err).Warningf("failed to load or generate the host key %s, using the embedded key", srv.HostKeyPath)
+	return parseHostKey([]byte(hostKeyBytes), "")
+}
+
+// LoadOrGenerateHostKey returns the PEM encoded key at path. If the file
+// doesn't exist, a new ed25519 key is generated and written to path with 0600
+// permissions, so the host identity is unique and survives restarts. The key
+// is written to a temporary file first, so a crash never leaves a partial key
+// at path.
+func LoadOrGenerateHostKey(path string) ([]byte, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err == nil || !os.IsNotExist(err) {
+		return pemBytes, err
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := gossh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return nil, err
+	}
+
+	pemBytes = pem.EncodeToMemory(block)
+	if err := writeHostKey(path, pemBytes); err != nil {
+		if os.IsExist(err) {
+			// another server generated it first
+			return ioutil.ReadFile(path)
+		}
+
+		return nil, err
+	}
+
+	log.Infof("generated a new host key in %s", path)
+	return pemBytes, nil
+}
+
+// writeHostKey writes pemBytes to a synced temporary file next to path, and
+// moves it to path. It's linked instead of renamed, so a key generated by
+// another server in the meantime isn't replaced.
+func writeHostKey(path string, pemBytes []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".host_key-")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+	if _, err := f.Write(pemBytes); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Link(f.Name(), path)
+}
+
+// waitForHostKey loads the host key, retrying until HostKeyWait expires while
+// the file is missing or only partially written
+func (srv *Server) waitForHostKey() (gossh.Signer, error) {
+	deadline := time.Now().Add(srv.HostKeyWait)
+	for {
+		signer, err := LoadHostKey(srv.HostKeyPath, srv.HostKeyPassphrase)
+		if err == nil || time.Now().After(deadline) {
+			return signer, err
+		}
+
+		log.WithError(err).Debugf("host key isn't ready, retrying")
+		time.Sleep(hostKeyPollInterval)
+	}
+}
+
+// HostKeyFingerprints returns the SHA256 fingerprints of the host keys loaded
+// by the server, so clients can verify them
+func (srv *Server) HostKeyFingerprints() []string {
+	fingerprints := make([]string, 0, len(srv.hostSigners))
+	for _, signer := range srv.hostSigners {
+		fingerprints = append(fingerprints, fmt.Sprintf("%s %s", signer.PublicKey().Type(), gossh.FingerprintSHA256(signer.PublicKey())))
+	}
+
+	return fingerprints
+}
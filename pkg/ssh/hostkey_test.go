@@ -0,0 +1,342 @@
+package ssh
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func writeEncryptedKey(t *testing.T, passphrase string) (string, gossh.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := gossh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "host_key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sshPub, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path, sshPub
+}
+
+func TestLoadHostKey_passphrase(t *testing.T) {
+	path, pub := writeEncryptedKey(t, "secret")
+
+	signer, err := LoadHostKey(path, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(signer.PublicKey().Marshal()) != string(pub.Marshal()) {
+		t.Error("loaded the wrong key")
+	}
+
+	if _, err := LoadHostKey(path, "wrong"); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("wrong passphrase didn't fail clearly: %v", err)
+	}
+
+	if _, err := LoadHostKey(path, ""); err == nil || !strings.Contains(err.Error(), "no passphrase") {
+		t.Errorf("missing passphrase didn't fail clearly: %v", err)
+	}
+}
+
+func Test_getServer_encryptedHostKey(t *testing.T) {
+	path, pub := writeEncryptedKey(t, "secret")
+
+	s := &Server{Shell: "sh", HostKeyPath: path, HostKeyPassphrase: "secret"}
+	if err := s.loadHostKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &gossh.ClientConfig{HostKeyCallback: gossh.FixedHostKey(pub)}
+	session, _, cleanup := newTestSession(t, s.getServer(), cfg)
+	defer cleanup()
+
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_loadHostKeys_fingerprint(t *testing.T) {
+	embedded, err := parseHostKey([]byte(hostKeyBytes), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, pub := writeEncryptedKey(t, "secret")
+	var tests = []struct {
+		name string
+		srv  *Server
+		key  gossh.PublicKey
+	}{
+		{name: "embedded", srv: &Server{}, key: embedded.PublicKey()},
+		{name: "host-key-path", srv: &Server{HostKeyPath: path, HostKeyPassphrase: "secret"}, key: pub},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			if err := tt.srv.loadHostKeys(); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := "host key fingerprint: " + tt.key.Type() + " " + gossh.FingerprintSHA256(tt.key)
+			if !strings.Contains(logs.String(), expected) {
+				t.Errorf("%q wasn't logged:\n%s", expected, logs.String())
+			}
+		})
+	}
+}
+
+func Test_loadHostKeys_wait(t *testing.T) {
+	path, pub := writeEncryptedKey(t, "secret")
+	key, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key is partially written until the writer finishes
+	if err := os.WriteFile(path, key[:len(key)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{HostKeyPath: path, HostKeyPassphrase: "secret"}
+	if err := s.loadHostKeys(); err == nil {
+		t.Fatal("partial key was loaded")
+	}
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		os.WriteFile(path, key, 0600)
+	}()
+
+	s.HostKeyWait = 5 * time.Second
+	if err := s.loadHostKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(s.hostSigners[0].PublicKey().Marshal()) != string(pub.Marshal()) {
+		t.Error("loaded the wrong key")
+	}
+}
+
+func TestLoadOrGenerateHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "host_key")
+	generated, err := LoadOrGenerateHostKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := gossh.ParsePrivateKey(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signer.PublicKey().Type() != gossh.KeyAlgoED25519 {
+		t.Errorf("generated a %s key", signer.PublicKey().Type())
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("key was written with mode %o", fi.Mode().Perm())
+	}
+
+	reused, err := LoadOrGenerateHostKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(reused) != string(generated) {
+		t.Error("the key was generated again")
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("the temporary key file was left behind: %d files", len(entries))
+	}
+}
+
+func TestLoadOrGenerateHostKey_concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "host_key")
+	keys := make([][]byte, 8)
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key, err := LoadOrGenerateHostKey(path)
+			if err != nil {
+				t.Error(err)
+			}
+
+			keys[i] = key
+		}(i)
+	}
+
+	wg.Wait()
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, key := range keys {
+		if string(key) != string(written) {
+			t.Errorf("server %d got a different key than the one written", i)
+		}
+	}
+}
+
+func Test_loadHostKeys_generate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "host_key")
+	s := &Server{HostKeyPath: path}
+	if err := s.loadHostKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("host key wasn't generated: %s", err)
+	}
+
+	s = &Server{HostKeyPath: filepath.Join(t.TempDir(), "missing"), HostKeyPassphrase: "secret"}
+	if err := s.loadHostKeys(); err == nil {
+		t.Error("an encrypted host key was generated")
+	}
+}
+
+func Test_loadHostKeys_unwritableDir(t *testing.T) {
+	// a regular file as the parent directory can't be written even by root
+	parent := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := ioutil.WriteFile(parent, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	embedded, err := parseHostKey([]byte(hostKeyBytes), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		fallback bool
+	}{
+		{name: "fail"},
+		{name: "fallback", fallback: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{HostKeyPath: filepath.Join(parent, "host_key"), HostKeyFallbackToEmbedded: tt.fallback}
+			err := s.loadHostKeys()
+			if !tt.fallback {
+				if err == nil {
+					t.Fatal("server started without a host key")
+				}
+
+				if !strings.Contains(err.Error(), s.HostKeyPath) {
+					t.Errorf("error doesn't mention the host key path: %s", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(s.hostSigners) != 1 || string(s.hostSigners[0].PublicKey().Marshal()) != string(embedded.PublicKey().Marshal()) {
+				t.Error("the embedded key wasn't used")
+			}
+		})
+	}
+}
+
+func Test_getServer_hostKeys(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := [][]byte{}
+	for _, key := range []crypto.PrivateKey{edKey, ecKey, rsaKey} {
+		block, err := gossh.MarshalPrivateKey(key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, pem.EncodeToMemory(block))
+	}
+
+	var tests = []struct {
+		name      string
+		keys      [][]byte
+		algorithm string
+		load      bool
+	}{
+		{name: "ed25519", keys: keys, algorithm: gossh.KeyAlgoED25519},
+		{name: "ecdsa", keys: keys, algorithm: gossh.KeyAlgoECDSA256},
+		{name: "rsa-sha2", keys: keys, algorithm: gossh.KeyAlgoRSASHA256},
+		{name: "single-key", keys: keys[:1], algorithm: gossh.KeyAlgoED25519},
+		{name: "loaded", keys: keys, algorithm: gossh.KeyAlgoED25519, load: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", HostKeys: tt.keys}
+			if tt.load {
+				if err := s.loadHostKeys(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg := &gossh.ClientConfig{HostKeyAlgorithms: []string{tt.algorithm}}
+			session, _, cleanup := newTestSession(t, s.getServer(), cfg)
+			defer cleanup()
+
+			if err := session.Run("true"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package ssh
+
+import (
+	"encoding/json"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const infoRequest = "info@okteto"
+
+// Info is the reply to the info@okteto global request
+type Info struct {
+	Version     string `json:"version"`
+	User        string `json:"user"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// handleInfoRequest replies with the server build and the identity of the
+// client, so clients can check them without starting a session
+func (srv *Server) handleInfoRequest(ctx ssh.Context, _ *ssh.Server, _ *gossh.Request) (bool, []byte) {
+	info := Info{
+		Version: srv.Version,
+		User:    ctx.User(),
+	}
+
+	if key, ok := ctx.Value(ssh.ContextKeyPublicKey).(ssh.PublicKey); ok && key != nil {
+		info.Fingerprint = gossh.FingerprintSHA256(key)
+	}
+
+	payload, err := json.Marshal(info)
+	if err != nil {
+		log.WithError(err).Error("failed to encode info reply")
+		return false, nil
+	}
+
+	return true, payload
+}
@@ -0,0 +1,35 @@
+package ssh
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_handleInfoRequest(t *testing.T) {
+	signer, pub := newTestSigner(t)
+	s := &Server{Shell: "sh", Version: "abc123", AuthorizedKeys: []ssh.PublicKey{pub}}
+	_, client, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+	defer cleanup()
+
+	ok, payload, err := client.SendRequest(infoRequest, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("info request was refused")
+	}
+
+	var info Info
+	if err := json.Unmarshal(payload, &info); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Info{Version: "abc123", User: "okteto", Fingerprint: gossh.FingerprintSHA256(pub)}
+	if info != expected {
+		t.Errorf("got %+v, expected %+v", info, expected)
+	}
+}
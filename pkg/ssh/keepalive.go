@@ -0,0 +1,139 @@
+package ssh
+
+import (
+	"context"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	keepAliveRequest = "keepalive@openssh.com"
+
+	// keepAliveProbe is sent to detect dead clients, which don't reply
+	keepAliveProbe = "keepalive@okteto"
+
+	defaultKeepAliveCountMax = 3
+)
+
+// sendAlive sends a keepalive request every interval until ctx is done, so
+// proxies between the client and the server see traffic during silent
+// commands. No reply is requested, since this isn't meant to detect dead
+// clients.
+func sendAlive(ctx context.Context, logger *log.Entry, interval time.Duration) {
+	conn, ok := ctx.Value(ssh.ContextKeyConn).(gossh.Conn)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, _, err := conn.SendRequest(keepAliveRequest, false, nil); err != nil {
+				logger.WithError(err).Debug("failed to send keepalive")
+				return
+			}
+		}
+	}
+}
+
+func (srv *Server) keepAliveCountMax() int {
+	if srv.KeepAliveCountMax > 0 {
+		return srv.KeepAliveCountMax
+	}
+
+	return defaultKeepAliveCountMax
+}
+
+// probeAlive sends a keepalive request that wants a reply every
+// KeepAliveInterval once the SSH connection of state is established, until
+// it's closed, and closes it when no reply arrives for KeepAliveCountMax
+// intervals in a row. Clients that refuse the request are alive too.
+func (srv *Server) probeAlive(state *connState, logger *log.Entry) {
+	var conn gossh.Conn
+	select {
+	case conn = <-state.established:
+	case <-state.closed:
+		return
+	}
+
+	ticker := time.NewTicker(srv.KeepAliveInterval)
+	defer ticker.Stop()
+
+	// requests that want a reply are sent one at a time, so a new one is
+	// only sent once the previous one is answered
+	var replied chan error
+	missed := 0
+	for {
+		select {
+		case <-state.closed:
+			return
+		case <-ticker.C:
+		}
+
+		if replied == nil {
+			replied = make(chan error, 1)
+			go func(replied chan error) {
+				_, _, err := conn.SendRequest(keepAliveProbe, true, nil)
+				replied <- err
+			}(replied)
+		}
+
+		timeout := time.NewTimer(srv.KeepAliveInterval)
+		select {
+		case <-state.closed:
+			timeout.Stop()
+			return
+		case err := <-replied:
+			timeout.Stop()
+			replied = nil
+			if err == nil {
+				missed = 0
+				continue
+			}
+
+			missed++
+		case <-timeout.C:
+			missed++
+		}
+
+		logger.Debugf("keepalive %d went unanswered", missed)
+		if missed >= srv.keepAliveCountMax() {
+			logger.Infof("closing the connection after %d unanswered keepalives", missed)
+			state.closeFor(EndReasonKeepAliveTimeout)
+			conn.Close()
+			return
+		}
+	}
+}
+
+// probedChannel passes the SSH connection of the channels handled by h to the
+// keepalive probe of the connection
+func probedChannel(h ssh.ChannelHandler) ssh.ChannelHandler {
+	return func(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+		if state, ok := ctx.Value(connectionStateKey).(*connState); ok {
+			state.establish(conn)
+		}
+
+		h(srv, conn, newChan, ctx)
+	}
+}
+
+// probedRequest passes the SSH connection of the global requests handled by h
+// to the keepalive probe of the connection
+func probedRequest(h ssh.RequestHandler) ssh.RequestHandler {
+	return func(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+		state, ok := ctx.Value(connectionStateKey).(*connState)
+		if conn, connOK := ctx.Value(ssh.ContextKeyConn).(gossh.Conn); ok && connOK {
+			state.establish(conn)
+		}
+
+		return h(ctx, srv, req)
+	}
+}
@@ -0,0 +1,294 @@
+package ssh
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_connectionHandler_aliveInterval(t *testing.T) {
+	s := &Server{Shell: "sh", AliveInterval: 50 * time.Millisecond}
+	l := newLocalListener()
+	go serveOnce(s.getServer(), l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &gossh.ClientConfig{HostKeyCallback: gossh.InsecureIgnoreHostKey()}
+	c, chans, reqs, err := gossh.NewClientConn(conn, l.Addr().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keepalives int32
+	globalReqs := make(chan *gossh.Request)
+	go func() {
+		for req := range reqs {
+			if req.Type == keepAliveRequest {
+				atomic.AddInt32(&keepalives, 1)
+			}
+		}
+
+		close(globalReqs)
+	}()
+
+	client := gossh.NewClient(c, chans, globalReqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer session.Close()
+	if err := session.Run("sleep 0.5"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&keepalives); n < 3 {
+		t.Errorf("got %d keepalives during a silent command", n)
+	}
+}
+
+func Test_connectionHandler_keepAliveInterval(t *testing.T) {
+	var tests = []struct {
+		name       string
+		responsive bool
+	}{
+		{name: "responsive", responsive: true},
+		{name: "unresponsive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+
+			s := &Server{Shell: "sh", KeepAliveInterval: 100 * time.Millisecond, KeepAliveCountMax: 3}
+			l := newLocalListener()
+			go serveOnce(s.getServer(), l)
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &gossh.ClientConfig{HostKeyCallback: gossh.InsecureIgnoreHostKey()}
+			c, chans, reqs, err := gossh.NewClientConn(conn, l.Addr().String(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var probes int32
+			go func() {
+				for req := range reqs {
+					if req.Type != keepAliveProbe {
+						continue
+					}
+
+					atomic.AddInt32(&probes, 1)
+					if tt.responsive {
+						req.Reply(false, nil)
+					}
+				}
+			}()
+
+			client := gossh.NewClient(c, chans, nil)
+			defer client.Close()
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer session.Close()
+			start := time.Now()
+			err = session.Run("sleep 1")
+			if tt.responsive {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if n := atomic.LoadInt32(&probes); n < 5 {
+					t.Errorf("got %d keepalives during the session", n)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatal("the connection wasn't closed")
+			}
+
+			// the first keepalive is sent after an interval, and the connection
+			// is closed once it goes unanswered for 3 intervals
+			if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > 900*time.Millisecond {
+				t.Errorf("the connection was closed after %s, expected 3 missed keepalives", elapsed)
+			}
+
+			if n := atomic.LoadInt32(&probes); n == 0 {
+				t.Error("no keepalives were sent")
+			}
+
+			waitForLog(t, logs, "session closed")
+			if !strings.Contains(logs.String(), "end.reason="+EndReasonKeepAliveTimeout) {
+				t.Errorf("expected end.reason=%s:\n%s", EndReasonKeepAliveTimeout, logs.String())
+			}
+		})
+	}
+}
+
+// dialUnresponsive returns a client of s that never answers keepalives, and
+// counts the ones it gets in probes
+func dialUnresponsive(t *testing.T, s *Server, probes *int32) *gossh.Client {
+	l := newLocalListener()
+	go serveOnce(s.getServer(), l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &gossh.ClientConfig{HostKeyCallback: gossh.InsecureIgnoreHostKey()}
+	c, chans, reqs, err := gossh.NewClientConn(conn, l.Addr().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for req := range reqs {
+			if req.Type == keepAliveProbe {
+				atomic.AddInt32(probes, 1)
+			}
+		}
+	}()
+
+	return gossh.NewClient(c, chans, nil)
+}
+
+func Test_connectionHandler_keepAliveWithoutSession(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+
+			defer c.Close()
+		}
+	}()
+
+	var tests = []struct {
+		name string
+		open func(*gossh.Client) error
+	}{
+		{
+			name: "forward",
+			open: func(client *gossh.Client) error {
+				_, err := client.Dial("tcp", target.Addr().String())
+				return err
+			},
+		},
+		{
+			name: "sftp",
+			open: func(client *gossh.Client) error {
+				_, err := sftp.NewClient(client)
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", AllowSFTPWithoutAuth: true, KeepAliveInterval: 100 * time.Millisecond, KeepAliveCountMax: 3}
+			var probes int32
+			client := dialUnresponsive(t, s, &probes)
+			defer client.Close()
+
+			// the forward and the sftp session are closed with the connection
+			if err := tt.open(client); err != nil {
+				t.Fatal(err)
+			}
+
+			closed := make(chan error, 1)
+			go func() { closed <- client.Wait() }()
+			select {
+			case <-closed:
+			case <-time.After(2 * time.Second):
+				t.Fatal("the connection wasn't closed")
+			}
+
+			if n := atomic.LoadInt32(&probes); n == 0 {
+				t.Error("no keepalives were sent")
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_keepAlivePerConnection(t *testing.T) {
+	s := &Server{Shell: "sh", KeepAliveInterval: 100 * time.Millisecond}
+	l := newLocalListener()
+	go serveOnce(s.getServer(), l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &gossh.ClientConfig{HostKeyCallback: gossh.InsecureIgnoreHostKey()}
+	c, chans, reqs, err := gossh.NewClientConn(conn, l.Addr().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var probes int32
+	go func() {
+		for req := range reqs {
+			if req.Type == keepAliveProbe {
+				atomic.AddInt32(&probes, 1)
+				req.Reply(false, nil)
+			}
+		}
+	}()
+
+	client := gossh.NewClient(c, chans, nil)
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := client.NewSession()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			defer session.Close()
+			if err := session.Run("sleep 0.6"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// a probe per session would send around 3 times as many
+	if n := atomic.LoadInt32(&probes); n == 0 || n > 8 {
+		t.Errorf("got %d keepalives for 3 sessions of one connection", n)
+	}
+}
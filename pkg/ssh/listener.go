@@ -0,0 +1,49 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tcpFastOpenQueueLen is the maximum number of pending TCP Fast Open requests
+const tcpFastOpenQueueLen = 256
+
+var errSockoptUnsupported = errors.New("socket option is not supported on this platform")
+
+func (srv *Server) listenConfig() *net.ListenConfig {
+	return &net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				if srv.ReusePort {
+					err := setReusePort(fd)
+					if errors.Is(err, errSockoptUnsupported) {
+						log.WithError(err).Warning("SO_REUSEPORT is not available")
+					} else if err != nil {
+						sockErr = err
+						return
+					}
+				}
+
+				if srv.TCPFastOpen {
+					if err := setTCPFastOpen(fd, tcpFastOpenQueueLen); err != nil {
+						log.WithError(err).Warning("TCP Fast Open is not available")
+					}
+				}
+			})
+			if err != nil {
+				return err
+			}
+
+			return sockErr
+		},
+	}
+}
+
+func (srv *Server) listen(addr string) (net.Listener, error) {
+	return srv.listenConfig().Listen(context.Background(), "tcp", addr)
+}
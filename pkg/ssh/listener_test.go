@@ -0,0 +1,80 @@
+package ssh
+
+import (
+	"net"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func Test_listen_reusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is only supported on linux")
+	}
+
+	s := &Server{ReusePort: true, TCPFastOpen: true}
+	l1, err := s.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l1.Close()
+
+	l2, err := s.listen(l1.Addr().String())
+	if err != nil {
+		t.Fatalf("second listener failed to bind with ReusePort: %s", err)
+	}
+
+	l2.Close()
+}
+
+func Test_listen_noReusePort(t *testing.T) {
+	s := &Server{}
+	l1, err := s.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l1.Close()
+
+	if l2, err := s.listen(l1.Addr().String()); err == nil {
+		l2.Close()
+		t.Fatal("second listener bound the same port without ReusePort")
+	}
+}
+
+func Test_getServer_bindAddress(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	s := &Server{Shell: "sh", Port: port, BindAddress: "127.0.0.1"}
+	server := s.getServer()
+	if expected := net.JoinHostPort("127.0.0.1", strconv.Itoa(port)); server.Addr != expected {
+		t.Fatalf("got address %s, expected %s", server.Addr, expected)
+	}
+
+	l, err = s.listen(server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go server.Serve(l)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("connection to the bind address failed: %s", err)
+	}
+
+	conn.Close()
+
+	if conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.2", strconv.Itoa(port))); err == nil {
+		conn.Close()
+		t.Fatal("server accepted a connection on another address")
+	}
+}
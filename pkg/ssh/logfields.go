@@ -0,0 +1,38 @@
+package ssh
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// logFieldName returns the name name is logged as, see LogFieldNames
+func (srv *Server) logFieldName(name string) string {
+	if renamed, ok := srv.LogFieldNames[name]; ok && renamed != "" {
+		return renamed
+	}
+
+	return name
+}
+
+// logFields returns fields with their names replaced by LogFieldNames
+func (srv *Server) logFields(fields log.Fields) log.Fields {
+	if len(srv.LogFieldNames) == 0 {
+		return fields
+	}
+
+	renamed := make(log.Fields, len(fields))
+	for k, v := range fields {
+		renamed[srv.logFieldName(k)] = v
+	}
+
+	return renamed
+}
+
+// routineLevel is the level of the logs of the normal lifecycle of sessions,
+// see QuietRoutine
+func (srv *Server) routineLevel() log.Level {
+	if srv.QuietRoutine {
+		return log.DebugLevel
+	}
+
+	return log.InfoLevel
+}
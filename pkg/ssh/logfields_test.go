@@ -0,0 +1,104 @@
+package ssh
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_connectionHandler_logFieldNames(t *testing.T) {
+	logs := captureLogs(t)
+	formatter := log.StandardLogger().Formatter
+	log.SetFormatter(&log.JSONFormatter{})
+	defer log.SetFormatter(formatter)
+
+	s := &Server{
+		Shell: "sh",
+		LogFieldNames: map[string]string{
+			"user":           "user_id",
+			"remote.address": "src_ip",
+			"end.reason":     "reason",
+		},
+	}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForLog(t, logs, "session closed")
+	var entry map[string]interface{}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "session closed") {
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, field := range []string{"user_id", "src_ip", "reason", "session.id"} {
+		if _, ok := entry[field]; !ok {
+			t.Errorf("%s wasn't logged: %v", field, entry)
+		}
+	}
+
+	for _, field := range []string{"user", "remote.address", "end.reason"} {
+		if _, ok := entry[field]; ok {
+			t.Errorf("%s was logged with its default name: %v", field, entry)
+		}
+	}
+}
+
+func Test_connectionHandler_quietRoutine(t *testing.T) {
+	logs := captureLogs(t)
+	log.SetLevel(log.InfoLevel)
+
+	s := &Server{Shell: "sh", QuietRoutine: true, MaxCommandLength: 10}
+	session, client, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	// nothing is printed around the logger either
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Stdout = w
+	err = session.Run("true")
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if printed, _ := ioutil.ReadAll(r); len(printed) > 0 {
+		t.Errorf("%q was printed to stdout", printed)
+	}
+
+	rejected, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rejected.Close()
+	if err := rejected.Run("echo a very long command"); err == nil {
+		t.Fatal("long command wasn't rejected")
+	}
+
+	waitForLog(t, logs, "end.reason=rejected")
+	for _, routine := range []string{"starting ssh session with command 'true'", "handling non PTY session"} {
+		if strings.Contains(logs.String(), routine) {
+			t.Errorf("%q was logged at info:\n%s", routine, logs.String())
+		}
+	}
+
+	if strings.Contains(logs.String(), "end.reason=exit") {
+		t.Errorf("the normal end of a session was logged at info:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,18 @@
+package ssh
+
+import (
+	"crypto/subtle"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) authorizePassword(ctx ssh.Context, password string) bool {
+	ok := subtle.ConstantTimeCompare([]byte(password), []byte(srv.Password)) == 1
+	if !ok {
+		log.Printf("access denied: wrong password")
+	}
+
+	srv.emitAuth(ctx, ok)
+	return ok
+}
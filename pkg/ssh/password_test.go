@@ -0,0 +1,54 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_passwordAuth(t *testing.T) {
+	signer, pub := newTestSigner(t)
+	var tests = []struct {
+		name     string
+		keys     []ssh.PublicKey
+		auth     gossh.AuthMethod
+		accepted bool
+	}{
+		{name: "correct", auth: gossh.Password("secret"), accepted: true},
+		{name: "wrong", auth: gossh.Password("wrong")},
+		{name: "empty", auth: gossh.Password("")},
+		{name: "key-with-password-configured", keys: []ssh.PublicKey{pub}, auth: gossh.PublicKeys(signer), accepted: true},
+		{name: "password-with-keys-configured", keys: []ssh.PublicKey{pub}, auth: gossh.Password("secret"), accepted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", Password: "secret", AuthorizedKeys: tt.keys}
+			l := newLocalListener()
+			go serveOnce(s.getServer(), l)
+
+			cfg := &gossh.ClientConfig{
+				User:            "okteto",
+				Auth:            []gossh.AuthMethod{tt.auth},
+				HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+			}
+
+			client, err := gossh.Dial("tcp", l.Addr().String(), cfg)
+			if !tt.accepted {
+				if err == nil {
+					client.Close()
+					t.Fatal("client was authenticated")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client.Close()
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// CheckAuthorizedKeysPermissions returns an error if the authorized_keys file
+// at path is writable by its group or others, or isn't owned by the user
+// running the server or root, like OpenSSH's StrictModes. A missing file is
+// not an error.
+func CheckAuthorizedKeysPermissions(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if perm := fi.Mode().Perm(); perm&0022 != 0 {
+		return fmt.Errorf("%s is writable by group or others (mode %04o)", path, perm)
+	}
+
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if owner := int(stat.Uid); owner != 0 && owner != geteuid() {
+			return fmt.Errorf("%s is owned by uid %d, expected %d or root", path, owner, geteuid())
+		}
+	}
+
+	return nil
+}
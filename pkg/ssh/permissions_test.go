@@ -0,0 +1,68 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_LoadAuthorizedKeysFile_permissions(t *testing.T) {
+	var tests = []struct {
+		name   string
+		mode   os.FileMode
+		strict bool
+		err    bool
+	}{
+		{name: "private", mode: 0600, strict: true},
+		{name: "world-readable", mode: 0644, strict: true},
+		{name: "world-writable", mode: 0666, strict: true, err: true},
+		{name: "group-writable", mode: 0620, strict: true, err: true},
+		{name: "world-writable-not-strict", mode: 0666},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "authorized_keys")
+			if err := ioutil.WriteFile(path, []byte(goodKey+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			// chmod isn't affected by the umask
+			if err := os.Chmod(path, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+
+			s := &Server{AuthorizedKeysPath: path, StrictModes: tt.strict}
+			err := s.LoadAuthorizedKeysFile()
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, expected error %t", err, tt.err)
+			}
+
+			if !tt.err && len(s.AuthorizedKeys) != 1 {
+				t.Errorf("loaded %d keys", len(s.AuthorizedKeys))
+			}
+		})
+	}
+}
+
+func TestCheckAuthorizedKeysPermissions_owner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	if err := ioutil.WriteFile(path, []byte(goodKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if os.Geteuid() == 0 {
+		// files owned by root are always accepted
+		if err := os.Chown(path, 12345, 12345); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		geteuid = func() int { return os.Geteuid() + 1 }
+		defer func() { geteuid = os.Geteuid }()
+	}
+
+	if err := CheckAuthorizedKeysPermissions(path); err == nil {
+		t.Error("a file owned by another user was accepted")
+	}
+}
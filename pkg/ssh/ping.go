@@ -0,0 +1,22 @@
+package ssh
+
+import (
+	"io"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+const pingSubsystem = "ping"
+
+// pingHandler answers "pong" without running any command, so monitoring can
+// check the channel and subsystem path cheaply
+func pingHandler(sess ssh.Session) {
+	if _, err := io.WriteString(sess, "pong\n"); err != nil {
+		log.WithError(err).Error("failed to answer ping")
+	}
+
+	if err := sess.Exit(0); err != nil {
+		log.WithError(err).Error("ping session failed to exit")
+	}
+}
@@ -0,0 +1,79 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func Test_pingSubsystem(t *testing.T) {
+	var tests = []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "enabled", enabled: true},
+		{name: "disabled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", EnablePing: tt.enabled}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			stdout, err := session.StdoutPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = session.RequestSubsystem(pingSubsystem)
+			if !tt.enabled {
+				if err == nil {
+					t.Fatal("ping subsystem is available while disabled")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := ioutil.ReadAll(stdout)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(out) != "pong\n" {
+				t.Errorf("got %q, expected pong", out)
+			}
+		})
+	}
+}
+
+func Test_allowedSubsystems(t *testing.T) {
+	var tests = []struct {
+		name      string
+		subsystem string
+		allowed   bool
+	}{
+		{name: "allowed", subsystem: pingSubsystem, allowed: true},
+		{name: "refused", subsystem: shellSubsystem("sh")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", EnablePing: true, ShellSubsystems: []string{"sh"}, AllowedSubsystems: []string{pingSubsystem}}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			err := session.RequestSubsystem(tt.subsystem)
+			if tt.allowed && err != nil {
+				t.Fatalf("%s was refused: %s", tt.subsystem, err)
+			}
+
+			if !tt.allowed && err == nil {
+				t.Fatalf("%s is available while not allowed", tt.subsystem)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package ssh
+
+import (
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// rateLimiter spreads the bytes passed to wait over time so that they don't
+// exceed rate bytes per second on average
+type rateLimiter struct {
+	rate int64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// clamp returns the prefix of p that can pass in one second
+func (l *rateLimiter) clamp(p []byte) []byte {
+	if int64(len(p)) > l.rate {
+		return p[:l.rate]
+	}
+
+	return p
+}
+
+// wait blocks until the bytes passed before n are within the rate, and
+// schedules n
+func (l *rateLimiter) wait(n int) {
+	if n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// limitedChannel rate limits the data read from and written to a channel
+type limitedChannel struct {
+	gossh.Channel
+	limiter *rateLimiter
+}
+
+func (c *limitedChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(c.limiter.clamp(p))
+	c.limiter.wait(n)
+	return n, err
+}
+
+func (c *limitedChannel) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := c.limiter.clamp(p)
+		c.limiter.wait(len(chunk))
+		n, err := c.Channel.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		p = p[n:]
+	}
+
+	return written, nil
+}
@@ -0,0 +1,70 @@
+package ssh
+
+import (
+	"context"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultReadinessInterval = 10 * time.Second
+
+// Ready reports whether the last run of ReadinessCommand succeeded. It's
+// always true when ReadinessCommand isn't set.
+func (srv *Server) Ready() bool {
+	if srv.ReadinessCommand == "" {
+		return true
+	}
+
+	return atomic.LoadInt32(&srv.ready) == 1
+}
+
+func (srv *Server) readinessInterval() time.Duration {
+	if srv.ReadinessInterval > 0 {
+		return srv.ReadinessInterval
+	}
+
+	return defaultReadinessInterval
+}
+
+// runReadinessProbe runs ReadinessCommand every ReadinessInterval until stop
+// is closed, and updates Ready with the result. The first result is always
+// logged, and the later ones when they change it.
+func (srv *Server) runReadinessProbe(stop <-chan struct{}) {
+	ticker := time.NewTicker(srv.readinessInterval())
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		srv.probeReadiness(first)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (srv *Server) probeReadiness(first bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), srv.readinessInterval())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, srv.Shell, "-c", srv.ReadinessCommand)
+	err := startTracked(cmd, cmd.Start)
+	if err == nil {
+		err = waitTracked(cmd)
+	}
+
+	ready := int32(0)
+	if err == nil {
+		ready = 1
+	}
+
+	if old := atomic.SwapInt32(&srv.ready, ready); first || old != ready {
+		if err != nil {
+			log.WithError(err).Warningf("readiness command '%s' failed, the server isn't ready", srv.ReadinessCommand)
+		} else {
+			log.Infof("readiness command '%s' succeeded, the server is ready", srv.ReadinessCommand)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitForReady(t *testing.T, s *Server, expected bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Ready() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Ready didn't become %t", expected)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_readinessCommand(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "environment-ready")
+	s := &Server{
+		Shell:             "sh",
+		ReadinessCommand:  "test -f " + marker,
+		ReadinessInterval: 20 * time.Millisecond,
+		AdminSocketPath:   filepath.Join(t.TempDir(), "admin.sock"),
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.runReadinessProbe(stop)
+
+	l, err := s.listenAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	go s.serveAdmin(l)
+
+	time.Sleep(100 * time.Millisecond)
+	if s.Ready() {
+		t.Fatal("ready while the readiness command fails")
+	}
+
+	if err := ioutil.WriteFile(marker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForReady(t, s, true)
+	adminCommand(t, s.AdminSocketPath, "READY")
+
+	if err := os.Remove(marker); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForReady(t, s, false)
+}
+
+func Test_readinessCommand_logsFirstResult(t *testing.T) {
+	logs := captureLogs(t)
+	s := &Server{Shell: "sh", ReadinessCommand: "false", ReadinessInterval: time.Hour}
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.runReadinessProbe(stop)
+
+	waitForLog(t, logs, "readiness command 'false' failed, the server isn't ready")
+}
+
+func Test_Ready_withoutCommand(t *testing.T) {
+	if !(&Server{}).Ready() {
+		t.Error("server without a readiness command isn't ready")
+	}
+}
@@ -0,0 +1,87 @@
+package ssh
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// children tracks the processes started by the server, so the orphan reaper
+// never collects an exit status that exec.Cmd.Wait is waiting for. Each
+// channel is closed once its process is waited for.
+var children = struct {
+	sync.Mutex
+	pids map[int]chan struct{}
+}{pids: map[int]chan struct{}{}}
+
+// startTracked runs start, which starts cmd, and registers the process
+func startTracked(cmd *exec.Cmd, start func() error) error {
+	children.Lock()
+	defer children.Unlock()
+
+	if err := start(); err != nil {
+		return err
+	}
+
+	children.pids[cmd.Process.Pid] = make(chan struct{})
+	return nil
+}
+
+// waitTracked waits for a process started with startTracked
+func waitTracked(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+
+	children.Lock()
+	if done, ok := children.pids[cmd.Process.Pid]; ok {
+		close(done)
+		delete(children.pids, cmd.Process.Pid)
+	}
+	children.Unlock()
+
+	return err
+}
+
+// signalTracked sends sig to the process group of cmd, if it was started with
+// startTracked and wasn't waited for yet. It returns the channel closed once
+// the process is waited for, or nil if there was nothing to signal.
+func signalTracked(cmd *exec.Cmd, sig syscall.Signal) (<-chan struct{}, error) {
+	children.Lock()
+	defer children.Unlock()
+	if cmd.Process == nil {
+		return nil, nil
+	}
+
+	done, ok := children.pids[cmd.Process.Pid]
+	if !ok {
+		return nil, nil
+	}
+
+	return done, signalProcessGroup(cmd, sig)
+}
+
+// terminateTracked sends SIGTERM to the process group of cmd and SIGKILL if
+// it doesn't exit within grace. A zero grace kills it right away.
+func terminateTracked(logger *log.Entry, cmd *exec.Cmd, grace time.Duration) error {
+	if grace <= 0 {
+		_, err := signalTracked(cmd, syscall.SIGKILL)
+		return err
+	}
+
+	done, err := signalTracked(cmd, syscall.SIGTERM)
+	if done == nil || err != nil {
+		return err
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(grace):
+	}
+
+	logger.Infof("command didn't exit %s after SIGTERM, sending SIGKILL", grace)
+	_, err = signalTracked(cmd, syscall.SIGKILL)
+	return err
+}
@@ -0,0 +1,93 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const reapInterval = time.Second
+
+// startReaper collects orphaned processes reparented to the server, which
+// happens when it runs as PID 1 or as a subreaper
+func startReaper(subreaper bool) error {
+	if subreaper {
+		if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+			return err
+		}
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGCHLD)
+	go func() {
+		ticker := time.NewTicker(reapInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sigCh:
+			case <-ticker.C:
+			}
+
+			reapOrphans()
+		}
+	}()
+
+	return nil
+}
+
+// reapOrphans collects the exited children that weren't started with
+// startTracked. Tracked children are skipped, even after they exit, since
+// exec.Cmd.Wait collects them.
+func reapOrphans() {
+	for _, pid := range childPids() {
+		children.Lock()
+		if _, ok := children.pids[pid]; ok {
+			children.Unlock()
+			continue
+		}
+
+		var status unix.WaitStatus
+		wpid, err := unix.Wait4(pid, &status, unix.WNOHANG, nil)
+		children.Unlock()
+		if err != nil {
+			log.WithError(err).Debugf("failed to reap process %d", pid)
+			continue
+		}
+
+		if wpid == pid {
+			log.Debugf("reaped orphaned process %d", pid)
+		}
+	}
+}
+
+// childPids returns the pids of the children of every thread of the server
+func childPids() []int {
+	files, err := filepath.Glob("/proc/self/task/*/children")
+	if err != nil {
+		return nil
+	}
+
+	pids := []int{}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+
+		for _, field := range strings.Fields(string(b)) {
+			if pid, err := strconv.Atoi(field); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+	}
+
+	return pids
+}
@@ -0,0 +1,112 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// zombieChildren returns the pids of the zombie children of the test process
+func zombieChildren(t *testing.T) []int {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zombies := []int{}
+	for _, stat := range stats {
+		b, err := ioutil.ReadFile(stat)
+		if err != nil {
+			continue
+		}
+
+		// the fields after the command name are state and ppid
+		fields := strings.Fields(string(b[strings.LastIndex(string(b), ")")+1:]))
+		if len(fields) < 2 || fields[0] != "Z" || fields[1] != strconv.Itoa(os.Getpid()) {
+			continue
+		}
+
+		pid, _ := strconv.Atoi(filepath.Base(filepath.Dir(stat)))
+		zombies = append(zombies, pid)
+	}
+
+	return zombies
+}
+
+// untrackedZombies returns the zombie children of the test process that
+// weren't started with startTracked
+func untrackedZombies(t *testing.T) []int {
+	children.Lock()
+	defer children.Unlock()
+
+	zombies := []int{}
+	for _, pid := range zombieChildren(t) {
+		if _, ok := children.pids[pid]; !ok {
+			zombies = append(zombies, pid)
+		}
+	}
+
+	return zombies
+}
+
+// inChildProcess runs the calling test again in a child process, so the
+// process wide changes it makes don't leak to the other tests. It returns
+// true in the child, which runs the body of the test.
+func inChildProcess(t *testing.T) bool {
+	const env = "OKTETO_REMOTE_CHILD_TEST"
+	if os.Getenv(env) == t.Name() {
+		return true
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.count=1")
+	cmd.Env = append(os.Environ(), env+"="+t.Name())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s:\n%s", err, out)
+	}
+
+	return false
+}
+
+func Test_startReaper(t *testing.T) {
+	// becoming a subreaper can't be undone
+	if !inChildProcess(t) {
+		return
+	}
+
+	if err := startReaper(true); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Shell: "sh"}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	// the shell exits right away but isn't waited for while the second sleep
+	// keeps its output open, and the first sleep is orphaned in the meantime
+	done := make(chan error, 1)
+	go func() { done <- session.Run("(sleep 0.1 >/dev/null 2>&1 &); sleep 2 &") }()
+
+	time.Sleep(500 * time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for {
+		zombies := untrackedZombies(t)
+		if len(zombies) == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("orphaned processes weren't reaped: %v", zombies)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package ssh
+
+import (
+	"errors"
+)
+
+func startReaper(subreaper bool) error {
+	return errors.New("reaping orphaned processes is only supported on linux")
+}
@@ -0,0 +1,52 @@
+package ssh
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_connectionHandler_killGrace(t *testing.T) {
+	var tests = []struct {
+		name     string
+		command  string
+		exitCode int
+		killed   bool
+	}{
+		{name: "exits-on-sigterm", command: "sleep 5", exitCode: 143},
+		{name: "ignores-sigterm", command: "trap '' TERM; sleep 5", exitCode: 137, killed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+
+			s := &Server{Shell: "sh", SessionIdleTimeout: 200 * time.Millisecond, KillGrace: 300 * time.Millisecond}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			start := time.Now()
+			err := session.Run(tt.command)
+			exitErr, ok := err.(*gossh.ExitError)
+			if !ok || exitErr.ExitStatus() != tt.exitCode {
+				t.Fatalf("expected exit code %d, got %v", tt.exitCode, err)
+			}
+
+			elapsed := time.Since(start)
+			if elapsed > 3*time.Second {
+				t.Errorf("command ran for %s", elapsed)
+			}
+
+			if tt.killed && elapsed < 500*time.Millisecond {
+				t.Errorf("command was killed before the grace period: %s", elapsed)
+			}
+
+			escalated := strings.Contains(logs.String(), "sending SIGKILL")
+			if escalated != tt.killed {
+				t.Errorf("got SIGKILL %t, expected %t:\n%s", escalated, tt.killed, logs.String())
+			}
+		})
+	}
+}
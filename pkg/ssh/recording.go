@@ -0,0 +1,146 @@
+package ssh
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RecordingSink returns where the recording of a PTY session is written
+type RecordingSink func(SessionInfo) (io.WriteCloser, error)
+
+// DirRecordingSink writes every recording to a <session id>.cast file in dir
+func DirRecordingSink(dir string) RecordingSink {
+	return func(info SessionInfo) (io.WriteCloser, error) {
+		return os.OpenFile(filepath.Join(dir, info.ID+".cast"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	}
+}
+
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// castRecorder writes the output of a session in the asciicast v2 format.
+// Write errors are logged and never interrupt the session.
+type castRecorder struct {
+	mu      sync.Mutex
+	logger  *log.Entry
+	w       io.WriteCloser
+	enc     *json.Encoder
+	start   time.Time
+	partial []byte
+	failed  bool
+	closed  bool
+}
+
+func newCastRecorder(logger *log.Entry, w io.WriteCloser, width, height int, term string) (*castRecorder, error) {
+	r := &castRecorder{
+		logger: logger,
+		w:      w,
+		enc:    json.NewEncoder(w),
+		start:  time.Now(),
+	}
+
+	header := castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+	}
+
+	if term != "" {
+		header.Env = map[string]string{"TERM": term}
+	}
+
+	if err := r.enc.Encode(header); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *castRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed || r.failed {
+		return len(p), nil
+	}
+
+	data := append(r.partial, p...)
+	r.partial = nil
+
+	// keep incomplete utf-8 sequences for the next write, so they aren't
+	// mangled by the json encoding
+	end := len(data)
+	for i := 0; i < utf8.UTFMax && end-i > 0; i++ {
+		if utf8.RuneStart(data[end-i-1]) {
+			if !utf8.FullRune(data[end-i-1:]) {
+				r.partial = append([]byte{}, data[end-i-1:]...)
+				data = data[:end-i-1]
+			}
+
+			break
+		}
+	}
+
+	if len(data) == 0 {
+		return len(p), nil
+	}
+
+	event := []interface{}{time.Since(r.start).Seconds(), "o", string(data)}
+	if err := r.enc.Encode(event); err != nil {
+		r.logger.WithError(err).Error("failed to write session recording, recording stopped")
+		r.failed = true
+	}
+
+	return len(p), nil
+}
+
+func (r *castRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+	return r.w.Close()
+}
+
+func (srv *Server) startRecording(logger *log.Entry, info SessionInfo, width, height int, term string) *castRecorder {
+	sink := srv.RecordingSink
+	if sink == nil && srv.SessionRecordDir != "" {
+		sink = DirRecordingSink(srv.SessionRecordDir)
+	}
+
+	if sink == nil {
+		return nil
+	}
+
+	w, err := sink(info)
+	if err != nil {
+		logger.WithError(err).Error("failed to open session recording")
+		return nil
+	}
+
+	r, err := newCastRecorder(logger, w, width, height, term)
+	if err != nil {
+		logger.WithError(err).Error("failed to start session recording")
+		w.Close()
+		return nil
+	}
+
+	return r
+}
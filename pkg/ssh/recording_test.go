@@ -0,0 +1,190 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type bufferCloser struct {
+	syncBuffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+func Test_recordingSink(t *testing.T) {
+	buf := &bufferCloser{}
+	var recorded SessionInfo
+	s := &Server{
+		Shell: "sh",
+		RecordingSink: func(info SessionInfo) (io.WriteCloser, error) {
+			recorded = info
+			return buf, nil
+		},
+	}
+
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.RequestPty("xterm", 40, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Run("echo recorded"); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorded.ID == "" || !recorded.PTY {
+		t.Errorf("sink got the wrong session info: %+v", recorded)
+	}
+
+	if !buf.closed {
+		t.Error("recording wasn't closed")
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(buf.String()))
+	if !scanner.Scan() {
+		t.Fatal("recording is empty")
+	}
+
+	var header castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatalf("invalid header: %s", err)
+	}
+
+	if header.Version != 2 || header.Width != 80 || header.Height != 40 {
+		t.Errorf("bad header: %+v", header)
+	}
+
+	var output bytes.Buffer
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event: %s", err)
+		}
+
+		if len(event) != 3 || event[1] != "o" {
+			t.Fatalf("bad event: %v", event)
+		}
+
+		output.WriteString(event[2].(string))
+	}
+
+	if !strings.Contains(output.String(), "recorded") {
+		t.Errorf("output wasn't recorded: %q", output.String())
+	}
+}
+
+func Test_recordingSink_clampedSize(t *testing.T) {
+	buf := &bufferCloser{}
+	s := &Server{
+		Shell:   "sh",
+		MaxCols: 60,
+		MaxRows: 20,
+		RecordingSink: func(info SessionInfo) (io.WriteCloser, error) {
+			return buf, nil
+		},
+	}
+
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.RequestPty("xterm", 40, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	var header castHeader
+	line, _, _ := strings.Cut(buf.String(), "\n")
+	if err := json.Unmarshal([]byte(line), &header); err != nil {
+		t.Fatalf("invalid header: %s", err)
+	}
+
+	if header.Width != 60 || header.Height != 20 {
+		t.Errorf("got a %dx%d header, expected the size of the pty", header.Width, header.Height)
+	}
+}
+
+func Test_castRecorder_splitRune(t *testing.T) {
+	buf := &bufferCloser{}
+	r, err := newCastRecorder(nil, buf, 80, 40, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	euro := []byte("€")
+	r.Write(euro[:1])
+	r.Write(euro[1:])
+
+	if !strings.Contains(buf.String(), `"€"`) {
+		t.Errorf("split rune wasn't recorded whole: %s", buf.String())
+	}
+}
+
+func Test_sessionRecordDir(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{Shell: "sh", SessionRecordDir: dir}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.RequestPty("xterm", 40, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Run("echo recorded"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.cast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Fatalf("expected one recording, got %v", files)
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	var header castHeader
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &header) != nil || header.Version != 2 {
+		t.Fatalf("invalid header: %s", scanner.Text())
+	}
+
+	events := 0
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event: %s", err)
+		}
+
+		if len(event) == 3 && event[1] == "o" {
+			events++
+		}
+	}
+
+	if events == 0 {
+		t.Error("no output was recorded")
+	}
+}
@@ -0,0 +1,28 @@
+package ssh
+
+import "regexp"
+
+const redactedText = "****"
+
+// DefaultLogRedactPatterns match passwords and tokens passed as key=value,
+// AWS access keys and bearer tokens
+var DefaultLogRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(password|passwd|secret|token)=\S+`),
+	regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9\-._~+/]+=*`),
+}
+
+// redact masks the matches of LogRedactPatterns in s, which is about to be
+// logged. What's sent to commands and clients is never redacted.
+func (srv *Server) redact(s string) string {
+	patterns := srv.LogRedactPatterns
+	if patterns == nil {
+		patterns = DefaultLogRedactPatterns
+	}
+
+	for _, p := range patterns {
+		s = p.ReplaceAllString(s, redactedText)
+	}
+
+	return s
+}
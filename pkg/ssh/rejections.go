@@ -0,0 +1,57 @@
+package ssh
+
+import (
+	"sync/atomic"
+)
+
+// The reasons counted by Rejections for the sessions the server refused,
+// because of its load or because the configuration doesn't allow them
+const (
+	RejectCapacity         = "capacity"
+	RejectLoadShed         = "load_shed"
+	RejectDrain            = "drain"
+	RejectSFTPOnly         = "sftp_only"
+	RejectCommandLength    = "command_length"
+	RejectEnvVars          = "env_vars"
+	RejectEnvSize          = "env_size"
+	RejectInteractiveShell = "interactive_shell"
+	RejectUnauthorized     = "unauthorized"
+)
+
+var rejectReasons = []string{
+	RejectCapacity,
+	RejectLoadShed,
+	RejectDrain,
+	RejectSFTPOnly,
+	RejectCommandLength,
+	RejectEnvVars,
+	RejectEnvSize,
+	RejectInteractiveShell,
+	RejectUnauthorized,
+}
+
+// Rejections returns how many sessions were rejected since the server
+// started, by reason. Every reason is present, even when zero.
+func (srv *Server) Rejections() map[string]uint64 {
+	rejections := make(map[string]uint64, len(rejectReasons))
+	for _, reason := range rejectReasons {
+		rejections[reason] = 0
+		if n, ok := srv.rejections.Load(reason); ok {
+			rejections[reason] = atomic.LoadUint64(n.(*uint64))
+		}
+	}
+
+	return rejections
+}
+
+func (srv *Server) countRejection(reason string) {
+	n, _ := srv.rejections.LoadOrStore(reason, new(uint64))
+	atomic.AddUint64(n.(*uint64), 1)
+}
+
+// draining reports whether Shutdown was called
+func (srv *Server) draining() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.shuttingDown
+}
@@ -0,0 +1,141 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_Rejections(t *testing.T) {
+	s := &Server{}
+	s.countRejection(RejectCapacity)
+	s.countRejection(RejectCapacity)
+	s.countRejection(RejectDrain)
+
+	expected := map[string]uint64{RejectCapacity: 2, RejectLoadShed: 0, RejectDrain: 1, RejectEnvSize: 0}
+	rejections := s.Rejections()
+	if len(rejections) != len(rejectReasons) {
+		t.Errorf("got %v, expected every reason", rejections)
+	}
+
+	for reason, n := range expected {
+		if rejections[reason] != n {
+			t.Errorf("got %d %s rejections, expected %d", rejections[reason], reason, n)
+		}
+	}
+}
+
+func Test_connectionHandler_rejectionReasons(t *testing.T) {
+	var tests = []struct {
+		name    string
+		server  func(*Server)
+		env     map[string]string
+		command string
+		reason  string
+	}{
+		{name: "sftp-only", command: "true", reason: RejectSFTPOnly},
+		{name: "command-length", server: func(s *Server) { s.MaxCommandLength = 4 }, command: "echo hello", reason: RejectCommandLength},
+		{name: "env-vars", server: func(s *Server) { s.MaxEnvVars = 1 }, env: map[string]string{"A": "1", "B": "2"}, command: "true", reason: RejectEnvVars},
+		{name: "env-size", server: func(s *Server) { s.MaxEnvSize = 4 }, env: map[string]string{"A": "12345"}, command: "true", reason: RejectEnvSize},
+		{name: "interactive-shell", server: func(s *Server) { s.DisableInteractiveShell = true }, reason: RejectInteractiveShell},
+		{name: "unauthorized", server: func(s *Server) { s.AuthorizeSession = func(SessionInfo) (bool, string) { return false, "denied" } }, command: "true", reason: RejectUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, pub := newTestSigner(t)
+			s := &Server{Shell: "sh", AuthorizedKeys: []ssh.PublicKey{pub}}
+			if tt.reason == RejectSFTPOnly {
+				s.SFTPOnlyKeys = []ssh.PublicKey{pub}
+			}
+
+			if tt.server != nil {
+				tt.server(s)
+			}
+
+			session, _, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+			defer cleanup()
+
+			for name, value := range tt.env {
+				if err := session.Setenv(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var err error
+			if tt.command == "" {
+				if err = session.Shell(); err == nil {
+					err = session.Wait()
+				}
+			} else {
+				err = session.Run(tt.command)
+			}
+
+			if exitErr, ok := err.(*gossh.ExitError); !ok || exitErr.ExitStatus() != ExitCodeRejected {
+				t.Fatalf("expected exit code %d, got %v", ExitCodeRejected, err)
+			}
+
+			for reason, n := range s.Rejections() {
+				expected := uint64(0)
+				if reason == tt.reason {
+					expected = 1
+				}
+
+				if n != expected {
+					t.Errorf("counted %d %s rejections, expected %d", n, reason, expected)
+				}
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_drain(t *testing.T) {
+	s := &Server{Shell: "sh"}
+	addr := startServer(t, s)
+
+	session, client, cleanup := newClientSession(t, addr, nil)
+	defer cleanup()
+
+	if err := session.Start("sleep 5"); err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	for i := 0; i < 50 && !s.draining(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rejected, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	rejected.Stderr = &stderr
+	err = rejected.Run("true")
+	exitErr, ok := err.(*gossh.ExitError)
+	if !ok || exitErr.ExitStatus() != ExitCodeBusy {
+		t.Fatalf("expected exit code %d, got %v", ExitCodeBusy, err)
+	}
+
+	if !strings.Contains(stderr.String(), "shutting down") {
+		t.Errorf("bad message: %q", stderr.String())
+	}
+
+	if n := s.Rejections()[RejectDrain]; n != 1 {
+		t.Errorf("counted %d drain rejections", n)
+	}
+
+	cleanup()
+	select {
+	case <-shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown didn't return after the connection closed")
+	}
+}
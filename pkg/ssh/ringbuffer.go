@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"io"
+	"sync"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// ringBuffer keeps the last size bytes written to it
+type ringBuffer struct {
+	mu   sync.Mutex
+	size int
+	buf  []byte
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{size: size, buf: make([]byte, 0, size)}
+}
+
+func (b *ringBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	if n >= b.size {
+		b.buf = append(b.buf[:0], p[n-b.size:]...)
+		return n, nil
+	}
+
+	if overflow := len(b.buf) + n - b.size; overflow > 0 {
+		b.buf = b.buf[:copy(b.buf, b.buf[overflow:])]
+	}
+
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+// Bytes returns a copy of the buffered bytes
+func (b *ringBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf...)
+}
+
+// SessionOutputs returns the tail of the combined stdout and stderr of every
+// active session, by session id. It's empty unless SessionBufferBytes is set.
+func (srv *Server) SessionOutputs() map[string][]byte {
+	outputs := map[string][]byte{}
+	srv.sessionBuffers.Range(func(id, b interface{}) bool {
+		outputs[id.(string)] = b.(*ringBuffer).Bytes()
+		return true
+	})
+
+	return outputs
+}
+
+// teeSession copies everything written to the stdout and stderr of a session
+// to w
+type teeSession struct {
+	ssh.Session
+	w io.Writer
+}
+
+func (s *teeSession) Write(p []byte) (int, error) {
+	n, err := s.Session.Write(p)
+	s.w.Write(p[:n])
+	return n, err
+}
+
+func (s *teeSession) Stderr() io.ReadWriter {
+	return &teeStderr{ReadWriter: s.Session.Stderr(), w: s.w}
+}
+
+type teeStderr struct {
+	io.ReadWriter
+	w io.Writer
+}
+
+func (s *teeStderr) Write(p []byte) (int, error) {
+	n, err := s.ReadWriter.Write(p)
+	s.w.Write(p[:n])
+	return n, err
+}
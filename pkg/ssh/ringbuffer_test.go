@@ -0,0 +1,86 @@
+package ssh
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ringBuffer(t *testing.T) {
+	var tests = []struct {
+		name     string
+		writes   []string
+		expected string
+	}{
+		{name: "under-size", writes: []string{"ab", "cd"}, expected: "abcd"},
+		{name: "overflow", writes: []string{"abcdef", "ghij"}, expected: "cdefghij"},
+		{name: "large-write", writes: []string{"ab", "0123456789"}, expected: "23456789"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newRingBuffer(8)
+			for _, w := range tt.writes {
+				b.Write([]byte(w))
+			}
+
+			if string(b.Bytes()) != tt.expected {
+				t.Errorf("got %q, expected %q", b.Bytes(), tt.expected)
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_sessionBuffer(t *testing.T) {
+	s := &Server{Shell: "sh", SessionBufferBytes: 16}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Start("seq 1000; echo tail-0123456789; sleep 5"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "tail-0123456789\n"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		outputs := s.SessionOutputs()
+		if len(outputs) > 1 {
+			t.Fatalf("got %d session buffers", len(outputs))
+		}
+
+		for _, out := range outputs {
+			if string(out) == expected {
+				session.Close()
+				return
+			}
+
+			if len(out) > 16 {
+				t.Fatalf("buffer grew to %d bytes", len(out))
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("the buffer doesn't have the tail of the output: %v", outputs)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_connectionHandler_sessionBufferDiscarded(t *testing.T) {
+	s := &Server{Shell: "sh", SessionBufferBytes: 16}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run("echo done"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.SessionOutputs()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("buffer wasn't discarded when the session ended")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
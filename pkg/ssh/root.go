@@ -0,0 +1,25 @@
+package ssh
+
+import (
+	"errors"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// geteuid is replaced in tests
+var geteuid = os.Geteuid
+
+// checkRoot warns when the server runs as root, or fails if RefuseRoot is set
+func (srv *Server) checkRoot() error {
+	if geteuid() != 0 {
+		return nil
+	}
+
+	if srv.RefuseRoot {
+		return errors.New("refusing to run as root, run the server as an unprivileged user")
+	}
+
+	log.Warning("the server is running as root, every session will have root privileges")
+	return nil
+}
@@ -0,0 +1,41 @@
+package ssh
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_checkRoot(t *testing.T) {
+	var tests = []struct {
+		name    string
+		euid    int
+		refuse  bool
+		warning bool
+		err     bool
+	}{
+		{name: "unprivileged", euid: 1000},
+		{name: "unprivileged-refuse", euid: 1000, refuse: true},
+		{name: "root", euid: 0, warning: true},
+		{name: "root-refuse", euid: 0, refuse: true, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			geteuid = func() int { return tt.euid }
+			defer func() { geteuid = os.Geteuid }()
+
+			s := &Server{RefuseRoot: tt.refuse}
+			err := s.checkRoot()
+			if (err != nil) != tt.err {
+				t.Errorf("got error %v, expected error %t", err, tt.err)
+			}
+
+			warned := strings.Contains(logs.String(), "running as root")
+			if warned != tt.warning {
+				t.Errorf("got warning %t, expected %t:\n%s", warned, tt.warning, logs.String())
+			}
+		})
+	}
+}
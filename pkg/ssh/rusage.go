@@ -0,0 +1,29 @@
+package ssh
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// resourceUsage returns the CPU time and max RSS of a finished command as log
+// fields. max_rss is in kilobytes on linux and in bytes on darwin, as
+// reported by getrusage.
+func resourceUsage(state *os.ProcessState) log.Fields {
+	if state == nil {
+		return nil
+	}
+
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return nil
+	}
+
+	return log.Fields{
+		"command.cpu.user":   time.Duration(rusage.Utime.Nano()).String(),
+		"command.cpu.system": time.Duration(rusage.Stime.Nano()).String(),
+		"command.max_rss":    rusage.Maxrss,
+	}
+}
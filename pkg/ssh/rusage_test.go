@@ -0,0 +1,58 @@
+package ssh
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_connectionHandler_resourceUsage(t *testing.T) {
+	logs := captureLogs(t)
+	formatter := log.StandardLogger().Formatter
+	log.SetFormatter(&log.JSONFormatter{})
+	defer log.SetFormatter(formatter)
+
+	s := &Server{Shell: "sh"}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run("i=0; while [ $i -lt 300000 ]; do i=$((i+1)); done"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForLog(t, logs, "session closed")
+	var entry map[string]interface{}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "session closed") {
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var cpu time.Duration
+	for _, field := range []string{"command.cpu.user", "command.cpu.system"} {
+		v, ok := entry[field].(string)
+		if !ok {
+			t.Fatalf("%s wasn't logged: %v", field, entry)
+		}
+
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cpu += d
+	}
+
+	if cpu == 0 {
+		t.Errorf("no CPU time was logged: %v", entry)
+	}
+
+	if rss, _ := entry["command.max_rss"].(float64); rss <= 0 {
+		t.Errorf("command.max_rss is %v", entry["command.max_rss"])
+	}
+}
@@ -0,0 +1,217 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type contextKey string
+
+const (
+	connectionIDKey    contextKey = "connection.id"
+	connectionStateKey contextKey = "connection.state"
+	sftpOnlyKey        contextKey = "sftp-only"
+)
+
+// The reasons logged as end.reason when a session is closed
+const (
+	EndReasonExit             = "exit"
+	EndReasonCommandError     = "command_error"
+	EndReasonInternalError    = "internal_error"
+	EndReasonRejected         = "rejected"
+	EndReasonIdleTimeout      = "idle_timeout"
+	EndReasonMaxDuration      = "max_duration"
+	EndReasonReadTimeout      = "read_timeout"
+	EndReasonClientDisconnect = "client_disconnect"
+	EndReasonKilled           = "killed"
+	EndReasonShutdown         = "shutdown"
+	EndReasonKeepAliveTimeout = "keepalive_timeout"
+)
+
+// SessionInfo describes a session to the hooks configured on Server
+type SessionInfo struct {
+	ID           string
+	ConnectionID string
+	User         string
+	RemoteAddr   string
+	Command      string
+	PTY          bool
+	Label        string
+}
+
+// SessionLabelEnv is the variable clients set to label their sessions, e.g.
+// with a CI job id, for correlation with external systems
+const SessionLabelEnv = "OKTETO_SESSION_LABEL"
+
+const maxSessionLabel = 64
+
+// sessionLabel returns the label sent by the client in env, with the
+// characters other than letters, digits and ._:/- replaced by _ and
+// truncated to maxSessionLabel characters
+func sessionLabel(env []string) string {
+	var label string
+	for _, kv := range env {
+		if strings.HasPrefix(kv, SessionLabelEnv+"=") {
+			label = strings.TrimPrefix(kv, SessionLabelEnv+"=")
+		}
+	}
+
+	label = sanitize(label, "._:/-")
+	if len(label) > maxSessionLabel {
+		label = label[:maxSessionLabel]
+	}
+
+	return label
+}
+
+// connState records why a connection stopped being readable
+type connState struct {
+	mu        sync.Mutex
+	start     time.Time
+	closedBy  string
+	maxLength time.Duration
+
+	// established receives the SSH connection once its first channel or
+	// global request arrives, and closed is closed with the connection
+	establishOnce sync.Once
+	established   chan gossh.Conn
+	closeOnce     sync.Once
+	closed        chan struct{}
+}
+
+func newConnState() *connState {
+	return &connState{start: time.Now(), established: make(chan gossh.Conn, 1), closed: make(chan struct{})}
+}
+
+func (c *connState) establish(conn gossh.Conn) {
+	c.establishOnce.Do(func() { c.established <- conn })
+}
+
+func (c *connState) readFailed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closedBy != "" {
+		return
+	}
+
+	var readTimeout *readTimeoutError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &readTimeout):
+		c.closedBy = EndReasonReadTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		if c.maxLength > 0 && time.Since(c.start) >= c.maxLength {
+			c.closedBy = EndReasonMaxDuration
+		} else {
+			c.closedBy = EndReasonIdleTimeout
+		}
+	default:
+		c.closedBy = EndReasonClientDisconnect
+	}
+}
+
+// closeFor records that the server closes the connection for reason
+func (c *connState) closeFor(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closedBy == "" {
+		c.closedBy = reason
+	}
+}
+
+func (c *connState) reason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closedBy
+}
+
+type trackedConn struct {
+	net.Conn
+	state *connState
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		c.state.readFailed(err)
+	}
+
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.state.closeOnce.Do(func() { close(c.state.closed) })
+	return c.Conn.Close()
+}
+
+// connCallback tags every connection with an id shared by all its sessions,
+// tracks why it was closed, and probes it with keepalives
+func (srv *Server) connCallback(ctx ssh.Context, conn net.Conn) net.Conn {
+	id := uuid.New().String()
+	ctx.SetValue(connectionIDKey, id)
+
+	state := newConnState()
+	if server, ok := ctx.Value(ssh.ContextKeyServer).(*ssh.Server); ok {
+		state.maxLength = server.MaxTimeout
+	}
+
+	ctx.SetValue(connectionStateKey, state)
+	if srv.ReadTimeout > 0 || srv.WriteTimeout > 0 {
+		conn = &deadlineConn{Conn: conn, readTimeout: srv.ReadTimeout, writeTimeout: srv.WriteTimeout}
+	}
+
+	if srv.KeepAliveInterval > 0 {
+		logger := log.WithFields(srv.logFields(log.Fields{"connection.id": id, "remote.address": conn.RemoteAddr().String()}))
+		go srv.probeAlive(state, logger)
+	}
+
+	return &trackedConn{Conn: conn, state: state}
+}
+
+// sessionEndReason returns why a session whose command returned err ended
+func sessionEndReason(ctx context.Context, err error) string {
+	if state, ok := ctx.Value(connectionStateKey).(*connState); ok {
+		if reason := state.reason(); reason != "" {
+			return reason
+		}
+	}
+
+	if err == nil {
+		return EndReasonExit
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return EndReasonCommandError
+	}
+
+	return EndReasonInternalError
+}
+
+// sanitize replaces the characters of s other than letters, digits and the
+// ones in allowed by _
+func sanitize(s, allowed string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune(allowed, r):
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+func connectionID(ctx context.Context) string {
+	id, _ := ctx.Value(connectionIDKey).(string)
+	return id
+}
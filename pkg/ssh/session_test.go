@@ -0,0 +1,493 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_connectionID(t *testing.T) {
+	logs := captureLogs(t)
+
+	s := &Server{Shell: "sh"}
+	session, client, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer second.Close()
+	if err := second.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	re := regexp.MustCompile(`connection.id=(\S+) .*session.id=(\S+)`)
+	sessions := map[string]string{}
+	for _, m := range re.FindAllStringSubmatch(logs.String(), -1) {
+		sessions[m[2]] = m[1]
+	}
+
+	if len(sessions) != 2 {
+		t.Fatalf("expected logs for 2 sessions, got %d:\n%s", len(sessions), logs.String())
+	}
+
+	connections := map[string]bool{}
+	for _, connID := range sessions {
+		connections[connID] = true
+	}
+
+	if len(connections) != 1 {
+		t.Errorf("sessions don't share the connection id: %v", sessions)
+	}
+}
+
+func Test_connectionHandler_remoteAddressNotResolved(t *testing.T) {
+	logs := captureLogs(t)
+
+	s := &Server{Shell: "sh"}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 127.0.0.1 reverse-resolves to localhost
+	if !regexp.MustCompile(`remote.address="?127\.0\.0\.1:\d+`).MatchString(logs.String()) {
+		t.Errorf("numeric remote address wasn't logged:\n%s", logs.String())
+	}
+
+	if strings.Contains(logs.String(), "localhost") {
+		t.Errorf("remote address was resolved:\n%s", logs.String())
+	}
+}
+
+func waitForLog(t *testing.T, logs *syncBuffer, substr string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), substr) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%q wasn't logged:\n%s", substr, logs.String())
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_connectionHandler_endReason(t *testing.T) {
+	var tests = []struct {
+		name        string
+		command     string
+		idleTimeout time.Duration
+		readTimeout time.Duration
+		expected    string
+	}{
+		{name: "exit", command: "true", expected: EndReasonExit},
+		{name: "command-error", command: "exit 2", expected: EndReasonCommandError},
+		{name: "idle-timeout", command: "cat", idleTimeout: 200 * time.Millisecond, expected: EndReasonIdleTimeout},
+		{name: "read-timeout", command: "cat", readTimeout: 200 * time.Millisecond, expected: EndReasonReadTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+
+			s := &Server{Shell: "sh", ReadTimeout: tt.readTimeout}
+			srv := s.getServer()
+			srv.IdleTimeout = tt.idleTimeout
+			session, _, cleanup := newTestSession(t, srv, nil)
+			defer cleanup()
+
+			if _, err := session.StdinPipe(); err != nil {
+				t.Fatal(err)
+			}
+
+			session.Run(tt.command)
+			waitForLog(t, logs, "session closed")
+
+			if !strings.Contains(logs.String(), "end.reason="+tt.expected) {
+				t.Errorf("expected end.reason=%s:\n%s", tt.expected, logs.String())
+			}
+		})
+	}
+}
+
+func Test_getServer_idleTimeout(t *testing.T) {
+	var tests = []struct {
+		name        string
+		idleTimeout time.Duration
+		closed      bool
+	}{
+		{name: "configured", idleTimeout: 200 * time.Millisecond, closed: true},
+		{name: "disabled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", IdleTimeout: tt.idleTimeout}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			if _, err := session.StdinPipe(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := session.Start("cat"); err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan error, 1)
+			go func() { done <- session.Wait() }()
+
+			select {
+			case <-done:
+				if !tt.closed {
+					t.Fatal("session was closed without an idle timeout")
+				}
+			case <-time.After(time.Second):
+				if tt.closed {
+					t.Fatalf("session wasn't closed after %s without traffic", tt.idleTimeout)
+				}
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_commandDuration(t *testing.T) {
+	logs := captureLogs(t)
+	formatter := log.StandardLogger().Formatter
+	log.SetFormatter(&log.JSONFormatter{})
+	defer log.SetFormatter(formatter)
+
+	s := &Server{Shell: "sh", ScratchDirBase: t.TempDir()}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run("sleep 0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForLog(t, logs, "session closed")
+	var entry map[string]interface{}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "session closed") {
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	durations := map[string]time.Duration{}
+	for _, field := range []string{"duration", "command.duration"} {
+		v, ok := entry[field].(string)
+		if !ok {
+			t.Fatalf("%s wasn't logged: %v", field, entry)
+		}
+
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		durations[field] = d
+	}
+
+	if durations["command.duration"] < 100*time.Millisecond {
+		t.Errorf("command.duration is %s, expected at least 100ms", durations["command.duration"])
+	}
+
+	if durations["duration"] < durations["command.duration"] {
+		t.Errorf("session duration %s is shorter than the command duration %s", durations["duration"], durations["command.duration"])
+	}
+
+	for _, field := range []string{"command.start", "command.end"} {
+		if _, ok := entry[field]; !ok {
+			t.Errorf("%s wasn't logged: %v", field, entry)
+		}
+	}
+}
+
+func Test_connectionHandler_maxSessions(t *testing.T) {
+	s := &Server{Shell: "sh", MaxSessions: 2}
+	_, client, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	// the sessions under the limit run until their stdin is closed
+	var running []*gossh.Session
+	var stdins []io.WriteCloser
+	for i := 0; i < s.MaxSessions; i++ {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer session.Close()
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := session.Start("cat > /dev/null"); err != nil {
+			t.Fatal(err)
+		}
+
+		running = append(running, session)
+		stdins = append(stdins, stdin)
+	}
+
+	for i := 0; i < 50 && atomic.LoadInt64(&s.openSessions) < int64(s.MaxSessions); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer session.Close()
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	err = session.Run("true")
+	exitErr, ok := err.(*gossh.ExitError)
+	if !ok || exitErr.ExitStatus() != ExitCodeRejected {
+		t.Fatalf("expected exit code %d, got %v", ExitCodeRejected, err)
+	}
+
+	if !strings.Contains(stderr.String(), "limit of 2 sessions") {
+		t.Errorf("bad message: %q", stderr.String())
+	}
+
+	if n := s.Rejections()[RejectCapacity]; n != 1 {
+		t.Errorf("counted %d capacity rejections", n)
+	}
+
+	for i, session := range running {
+		stdins[i].Close()
+		if err := session.Wait(); err != nil {
+			t.Errorf("session %d failed: %s", i, err)
+		}
+	}
+}
+
+func Test_connectionHandler_loadShed(t *testing.T) {
+	var tests = []struct {
+		name string
+		shed bool
+	}{
+		{name: "accepted"},
+		{name: "busy", shed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", LoadShedFunc: func() bool { return tt.shed }}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			var stdout, stderr bytes.Buffer
+			session.Stdout = &stdout
+			session.Stderr = &stderr
+			err := session.Run("echo ran")
+			if !tt.shed {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if n := s.Rejections()[RejectLoadShed]; n != 0 {
+					t.Errorf("counted %d load shed rejections", n)
+				}
+
+				return
+			}
+
+			if n := s.Rejections()[RejectLoadShed]; n != 1 {
+				t.Errorf("counted %d load shed rejections", n)
+			}
+
+			exitErr, ok := err.(*gossh.ExitError)
+			if !ok || exitErr.ExitStatus() != ExitCodeBusy {
+				t.Fatalf("expected exit code %d, got %v", ExitCodeBusy, err)
+			}
+
+			if !strings.Contains(stderr.String(), "server busy") {
+				t.Errorf("bad message: %q", stderr.String())
+			}
+
+			if stdout.Len() > 0 {
+				t.Errorf("the command ran: %q", stdout.String())
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_authorizeSession(t *testing.T) {
+	maintenance := false
+	var authorized SessionInfo
+	s := &Server{
+		Shell: "sh",
+		AuthorizeSession: func(info SessionInfo) (bool, string) {
+			authorized = info
+			if maintenance {
+				return false, "the environment is under maintenance until 18:00 UTC"
+			}
+
+			return true, ""
+		},
+	}
+
+	var tests = []struct {
+		name        string
+		maintenance bool
+	}{
+		{name: "allowed"},
+		{name: "maintenance-window", maintenance: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maintenance = tt.maintenance
+			session, _, cleanup := newTestSession(t, s.getServer(), &gossh.ClientConfig{User: "okteto"})
+			defer cleanup()
+
+			var stdout, stderr bytes.Buffer
+			session.Stdout = &stdout
+			session.Stderr = &stderr
+			err := session.Run("echo ran")
+			if authorized.User != "okteto" || authorized.Command != "echo ran" {
+				t.Errorf("hook got the wrong session info: %+v", authorized)
+			}
+
+			if !tt.maintenance {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			exitErr, ok := err.(*gossh.ExitError)
+			if !ok || exitErr.ExitStatus() != ExitCodeRejected {
+				t.Fatalf("expected exit code %d, got %v", ExitCodeRejected, err)
+			}
+
+			if !strings.Contains(stderr.String(), "under maintenance") {
+				t.Errorf("reason wasn't shown: %q", stderr.String())
+			}
+
+			if stdout.Len() > 0 {
+				t.Errorf("the command ran: %q", stdout.String())
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_shellCrashed(t *testing.T) {
+	var tests = []struct {
+		name    string
+		command string
+		crashed bool
+		code    int
+	}{
+		{name: "crashed", command: "kill -SEGV $$", crashed: true, code: 128 + 11},
+		{name: "command-failed", command: "sh -c 'kill -SEGV $$'; exit 5", code: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+
+			s := &Server{Shell: "sh"}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			err := session.Run(tt.command)
+			exitErr, ok := err.(*gossh.ExitError)
+			if !ok || exitErr.ExitStatus() != tt.code {
+				t.Fatalf("expected exit code %d, got %v", tt.code, err)
+			}
+
+			waitForLog(t, logs, "session closed")
+			if crashed := strings.Contains(logs.String(), "shell.crashed=true"); crashed != tt.crashed {
+				t.Errorf("got shell.crashed=%t, expected %t:\n%s", crashed, tt.crashed, logs.String())
+			}
+
+			if tt.crashed && !strings.Contains(logs.String(), `shell.signal="segmentation fault"`) {
+				t.Errorf("the signal wasn't logged:\n%s", logs.String())
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_shellCrashedFieldNames(t *testing.T) {
+	logs := captureLogs(t)
+
+	s := &Server{Shell: "sh", LogFieldNames: map[string]string{"shell.signal": "signal"}}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run("kill -SEGV $$"); err == nil {
+		t.Fatal("crashed shell exited successfully")
+	}
+
+	waitForLog(t, logs, "session closed")
+	if strings.Contains(logs.String(), "shell.signal") || !strings.Contains(logs.String(), `signal="segmentation fault"`) {
+		t.Errorf("the signal wasn't logged with its configured name:\n%s", logs.String())
+	}
+}
+
+func Test_sessionLabel(t *testing.T) {
+	var tests = []struct {
+		name     string
+		env      []string
+		expected string
+	}{
+		{name: "missing", env: []string{"LANG=C"}},
+		{name: "plain", env: []string{SessionLabelEnv + "=ci-job/1234"}, expected: "ci-job/1234"},
+		{name: "sanitized", env: []string{SessionLabelEnv + "=TICKET 42\n\"x\""}, expected: "TICKET_42__x_"},
+		{name: "truncated", env: []string{SessionLabelEnv + "=" + strings.Repeat("a", 100)}, expected: strings.Repeat("a", maxSessionLabel)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if label := sessionLabel(tt.env); label != tt.expected {
+				t.Errorf("got %q, expected %q", label, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_sessionLabel(t *testing.T) {
+	logs := captureLogs(t)
+
+	s := &Server{Shell: "sh"}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Setenv(SessionLabelEnv, "ci job 1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForLog(t, logs, "session closed")
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "session closed") && !strings.Contains(line, "session.label=ci_job_1234") {
+			t.Errorf("the label wasn't logged: %s", line)
+		}
+	}
+}
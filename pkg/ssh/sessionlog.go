@@ -0,0 +1,26 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// openSessionLog returns a logger with fields that writes to a
+// <user>-<session id>.log file of its own in PerSessionLogDir, and the
+// function that closes it
+func (srv *Server) openSessionLog(sessionID, user string, fields log.Fields) (*log.Entry, func(), error) {
+	path := filepath.Join(srv.PerSessionLogDir, fmt.Sprintf("%s-%s.log", sanitize(user, "._-"), sessionID))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger := log.New()
+	logger.SetOutput(f)
+	logger.SetFormatter(log.StandardLogger().Formatter)
+	logger.SetLevel(log.GetLevel())
+	return logger.WithFields(fields), func() { f.Close() }, nil
+}
@@ -0,0 +1,64 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_perSessionLogDir(t *testing.T) {
+	logs := captureLogs(t)
+	dir := t.TempDir()
+	s := &Server{Shell: "sh", PerSessionLogDir: dir}
+
+	for _, user := range []string{"alice", "bob"} {
+		session, _, cleanup := newTestSession(t, s.getServer(), &gossh.ClientConfig{User: user})
+		if err := session.Run("echo " + user); err != nil {
+			t.Fatal(err)
+		}
+
+		cleanup()
+	}
+
+	waitForLog(t, logs, "user=bob")
+	files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("expected two session logs, got %v", files)
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, user+"-*.log"))
+		if len(matches) != 1 {
+			t.Fatalf("expected one log for %s, got %v", user, matches)
+		}
+
+		content, err := ioutil.ReadFile(matches[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(content), "command 'echo "+user+"'") || !strings.Contains(string(content), "handling non PTY session") {
+			t.Errorf("%s's log is missing the session logs:\n%s", user, content)
+		}
+
+		other := map[string]string{"alice": "bob", "bob": "alice"}[user]
+		if strings.Contains(string(content), "user="+other) {
+			t.Errorf("%s's log has the logs of %s:\n%s", user, other, content)
+		}
+	}
+
+	if strings.Contains(logs.String(), "handling non PTY session") {
+		t.Errorf("session logs were written to the main logger:\n%s", logs.String())
+	}
+
+	if strings.Count(logs.String(), "starting ssh session") != 2 {
+		t.Errorf("the session starts weren't written to the main logger:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,64 @@
+package ssh
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+)
+
+func (srv *Server) sftpHandler(sess ssh.Session) {
+	debugStream := ioutil.Discard
+	serverOptions := []sftp.ServerOption{
+		sftp.WithDebug(debugStream),
+	}
+	if srv.SFTPReadOnly {
+		serverOptions = append(serverOptions, sftp.ReadOnly())
+	}
+	server, err := sftp.NewServer(
+		sess,
+		serverOptions...,
+	)
+	if err != nil {
+		log.Printf("sftp server init error: %s\n", err)
+		return
+	}
+	if err := server.Serve(); err == io.EOF {
+		server.Close()
+		log.Println("sftp client exited session.")
+	} else if err != nil {
+		log.Println("sftp server completed with error:", err)
+	}
+}
+
+// sftpModesEnabled returns true if the sftp files and directories are created
+// with the configured modes
+func (srv *Server) sftpModesEnabled() bool {
+	return srv.SFTPUmask != 0 || srv.SFTPDefaultFileMode != 0 || srv.SFTPDefaultDirMode != 0
+}
+
+// sftpSubsystemHandler returns the handler for the sftp subsystem
+func (srv *Server) sftpSubsystemHandler() ssh.SubsystemHandler {
+	// the modes only apply to writes, which are refused in read-only mode
+	if srv.SFTPRoot == "" && (!srv.sftpModesEnabled() || srv.SFTPReadOnly) {
+		return srv.sftpHandler
+	}
+
+	return func(sess ssh.Session) {
+		fs, err := srv.newSFTPFS()
+		if err != nil {
+			log.WithError(err).Error("failed to start the sftp server")
+			return
+		}
+
+		server := sftp.NewRequestServer(sess, fs.handlers(), sftp.WithStartDirectory(fs.startDirectory()))
+		if err := server.Serve(); err == io.EOF {
+			server.Close()
+			log.Println("sftp client exited session.")
+		} else if err != nil {
+			log.Println("sftp server completed with error:", err)
+		}
+	}
+}
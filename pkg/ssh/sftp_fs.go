@@ -0,0 +1,291 @@
+package ssh
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpFiles are the files served by sftpFS
+type sftpFiles interface {
+	OpenFile(name string, flag int, perm os.FileMode) (*os.File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Readlink(name string) (string, error)
+	Mkdir(name string, perm os.FileMode) error
+	Remove(name string) error
+	Rename(oldname, newname string) error
+	Link(oldname, newname string) error
+	Symlink(target, link string) error
+	Chmod(name string, mode os.FileMode) error
+	Chown(name string, uid, gid int) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Truncate(name string, size int64) error
+}
+
+// osFiles are the files of the local filesystem
+type osFiles struct{}
+
+func (osFiles) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFiles) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFiles) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osFiles) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+func (osFiles) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (osFiles) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (osFiles) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFiles) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (osFiles) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (osFiles) Symlink(target, link string) error {
+	return os.Symlink(target, link)
+}
+
+func (osFiles) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (osFiles) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+func (osFiles) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (osFiles) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+// sftpFS serves sftp requests from files, creating files and directories with
+// fixed modes instead of the ones derived from the process umask
+type sftpFS struct {
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	umask    os.FileMode
+	root     string
+	readOnly bool
+	files    sftpFiles
+}
+
+func (srv *Server) newSFTPFS() (*sftpFS, error) {
+	files, err := newSFTPFiles(srv.SFTPRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &sftpFS{fileMode: 0644, dirMode: 0755, umask: srv.SFTPUmask, root: srv.SFTPRoot, readOnly: srv.SFTPReadOnly, files: files}
+	if srv.SFTPDefaultFileMode != 0 {
+		fs.fileMode = srv.SFTPDefaultFileMode
+	}
+
+	if srv.SFTPDefaultDirMode != 0 {
+		fs.dirMode = srv.SFTPDefaultDirMode
+	}
+
+	return fs, nil
+}
+
+// startDirectory returns the directory relative paths are resolved from, the
+// root when there's one, and the working directory of the server otherwise,
+// like with sftp.Server
+func (fs *sftpFS) startDirectory() string {
+	if fs.root != "" {
+		return "/"
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		return wd
+	}
+
+	return "/"
+}
+
+func (fs *sftpFS) handlers() sftp.Handlers {
+	return sftp.Handlers{FileGet: fs, FilePut: fs, FileCmd: fs, FileList: fs}
+}
+
+func (fs *sftpFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return fs.files.OpenFile(r.Filepath, os.O_RDONLY, 0)
+}
+
+func (fs *sftpFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if fs.readOnly {
+		return nil, os.ErrPermission
+	}
+
+	pflags := r.Pflags()
+	flags := os.O_WRONLY
+	if pflags.Read {
+		flags = os.O_RDWR
+	}
+
+	if pflags.Append {
+		flags |= os.O_APPEND
+	}
+
+	if pflags.Creat {
+		flags |= os.O_CREATE
+	}
+
+	if pflags.Trunc {
+		flags |= os.O_TRUNC
+	}
+
+	if pflags.Excl {
+		flags |= os.O_EXCL
+	}
+
+	_, statErr := fs.files.Lstat(r.Filepath)
+	f, err := fs.files.OpenFile(r.Filepath, flags, fs.fileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	// chmod isn't affected by the process umask, unlike open
+	if os.IsNotExist(statErr) {
+		if err := f.Chmod(fs.fileMode &^ fs.umask); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (fs *sftpFS) Filecmd(r *sftp.Request) error {
+	if fs.readOnly {
+		return os.ErrPermission
+	}
+
+	switch r.Method {
+	case "Setstat":
+		return fs.setstat(r)
+	case "Rename", "PosixRename":
+		return fs.files.Rename(r.Filepath, r.Target)
+	case "Rmdir", "Remove":
+		return fs.files.Remove(r.Filepath)
+	case "Mkdir":
+		if err := fs.files.Mkdir(r.Filepath, fs.dirMode); err != nil {
+			return err
+		}
+
+		return fs.files.Chmod(r.Filepath, fs.dirMode&^fs.umask)
+	case "Link":
+		return fs.files.Link(r.Filepath, r.Target)
+	case "Symlink":
+		// the target is kept as sent by the client, and the link is in Target
+		return fs.files.Symlink(r.Filepath, r.Target)
+	}
+
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (fs *sftpFS) setstat(r *sftp.Request) error {
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+	if flags.Permissions {
+		if err := fs.files.Chmod(r.Filepath, attrs.FileMode()); err != nil {
+			return err
+		}
+	}
+
+	if flags.Size {
+		if err := fs.files.Truncate(r.Filepath, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+
+	if flags.Acmodtime {
+		if err := fs.files.Chtimes(r.Filepath, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0)); err != nil {
+			return err
+		}
+	}
+
+	if flags.UidGid {
+		return fs.files.Chown(r.Filepath, int(attrs.UID), int(attrs.GID))
+	}
+
+	return nil
+}
+
+func (fs *sftpFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		entries, err := fs.files.ReadDir(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+
+		return listerAt(entries), nil
+	case "Stat":
+		fi, err := fs.files.Stat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+
+		return listerAt{fi}, nil
+	}
+
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat serves the lstat requests, which don't follow a symlink at the end of
+// the path
+func (fs *sftpFS) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	fi, err := fs.files.Lstat(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	return listerAt{fi}, nil
+}
+
+// Readlink returns the target of the link at p as it was created
+func (fs *sftpFS) Readlink(p string) (string, error) {
+	return fs.files.Readlink(p)
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
@@ -0,0 +1,284 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// resolveInRoot resolves paths as if the root was /: .. stops at the root,
+// absolute symlinks are relative to it, and /proc magic links are refused
+const resolveInRoot = unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS
+
+func newSFTPFiles(root string) (sftpFiles, error) {
+	if root == "" {
+		return osFiles{}, nil
+	}
+
+	return rootFiles(root), nil
+}
+
+// rootFiles are the files below a root directory. Every path is resolved by
+// the kernel with openat2, and the operations work on the resolved file
+// descriptors, so a concurrent rename or symlink can't make them leave the
+// root between a check and its use.
+type rootFiles string
+
+// openat resolves name inside the root and opens it with flags
+func (root rootFiles) openat(name string, flags int, mode uint32) (int, error) {
+	dir, err := unix.Open(string(root), unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	defer unix.Close(dir)
+	how := &unix.OpenHow{Flags: uint64(flags | unix.O_CLOEXEC), Resolve: resolveInRoot}
+	if flags&unix.O_CREAT != 0 {
+		how.Mode = uint64(mode)
+	}
+
+	fd, err := unix.Openat2(dir, relativeToRoot(name), how)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return fd, nil
+}
+
+// parent opens the directory of name, and returns it with the last element of
+// name. The root itself has no parent.
+func (root rootFiles) parent(name string) (int, string, error) {
+	clean := path.Clean("/" + name)
+	if clean == "/" {
+		return -1, "", &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+
+	dir, err := root.openat(path.Dir(clean), unix.O_PATH|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return -1, "", err
+	}
+
+	return dir, path.Base(clean), nil
+}
+
+// relativeToRoot returns name relative to the root
+func relativeToRoot(name string) string {
+	rel := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if rel == "" {
+		return "."
+	}
+
+	return rel
+}
+
+// fdPath returns the path of fd, to change the attributes of files opened
+// with O_PATH
+func fdPath(fd int) string {
+	return fmt.Sprintf("/proc/self/fd/%d", fd)
+}
+
+func (root rootFiles) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	fd, err := root.openat(name, flag, uint32(perm.Perm()))
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// stat returns the FileInfo of the file opened with flags
+func (root rootFiles) stat(name string, flags int) (os.FileInfo, error) {
+	fd, err := root.openat(name, unix.O_PATH|flags, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+	return f.Stat()
+}
+
+func (root rootFiles) Stat(name string) (os.FileInfo, error) {
+	return root.stat(name, 0)
+}
+
+func (root rootFiles) Lstat(name string) (os.FileInfo, error) {
+	return root.stat(name, unix.O_NOFOLLOW)
+}
+
+// ReadDir returns the entries of the directory name sorted by name, each one
+// looked up in the opened directory instead of by path
+func (root rootFiles) ReadDir(name string) ([]os.FileInfo, error) {
+	f, err := root.OpenFile(name, os.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	entries := make([]os.FileInfo, 0, len(names))
+	for _, n := range names {
+		fd, err := unix.Openat(int(f.Fd()), n, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err != nil {
+			// removed since it was listed
+			continue
+		}
+
+		entry := os.NewFile(uintptr(fd), n)
+		fi, err := entry.Stat()
+		entry.Close()
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, fi)
+	}
+
+	return entries, nil
+}
+
+func (root rootFiles) Readlink(name string) (string, error) {
+	fd, err := root.openat(name, unix.O_PATH|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return "", err
+	}
+
+	defer unix.Close(fd)
+	for size := 128; ; size *= 2 {
+		b := make([]byte, size)
+		n, err := unix.Readlinkat(fd, "", b)
+		if err != nil {
+			return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+		}
+
+		if n < size {
+			return string(b[:n]), nil
+		}
+	}
+}
+
+func (root rootFiles) Mkdir(name string, perm os.FileMode) error {
+	dir, base, err := root.parent(name)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(dir)
+	if err := unix.Mkdirat(dir, base, uint32(perm.Perm())); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// Remove removes the file or the empty directory name, like os.Remove
+func (root rootFiles) Remove(name string) error {
+	dir, base, err := root.parent(name)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(dir)
+	err = unix.Unlinkat(dir, base, 0)
+	if err == nil {
+		return nil
+	}
+
+	rmdirErr := unix.Unlinkat(dir, base, unix.AT_REMOVEDIR)
+	if rmdirErr == nil {
+		return nil
+	}
+
+	if rmdirErr != unix.ENOTDIR {
+		err = rmdirErr
+	}
+
+	return &os.PathError{Op: "remove", Path: name, Err: err}
+}
+
+func (root rootFiles) Rename(oldname, newname string) error {
+	return root.twoPaths("rename", oldname, newname, func(oldDir int, oldBase string, newDir int, newBase string) error {
+		return unix.Renameat(oldDir, oldBase, newDir, newBase)
+	})
+}
+
+func (root rootFiles) Link(oldname, newname string) error {
+	return root.twoPaths("link", oldname, newname, func(oldDir int, oldBase string, newDir int, newBase string) error {
+		return unix.Linkat(oldDir, oldBase, newDir, newBase, 0)
+	})
+}
+
+// twoPaths runs op with the parents and the last elements of oldname and
+// newname
+func (root rootFiles) twoPaths(op, oldname, newname string, f func(int, string, int, string) error) error {
+	oldDir, oldBase, err := root.parent(oldname)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(oldDir)
+	newDir, newBase, err := root.parent(newname)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(newDir)
+	if err := f(oldDir, oldBase, newDir, newBase); err != nil {
+		return &os.LinkError{Op: op, Old: oldname, New: newname, Err: err}
+	}
+
+	return nil
+}
+
+// Symlink creates link pointing to target. Since paths are resolved as if the
+// root was /, absolute targets are relative to the root too.
+func (root rootFiles) Symlink(target, link string) error {
+	dir, base, err := root.parent(link)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(dir)
+	if err := unix.Symlinkat(target, dir, base); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+
+	return nil
+}
+
+// attr runs f with the path of the opened file name
+func (root rootFiles) attr(name string, f func(string) error) error {
+	fd, err := root.openat(name, unix.O_PATH, 0)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(fd)
+	return f(fdPath(fd))
+}
+
+func (root rootFiles) Chmod(name string, mode os.FileMode) error {
+	return root.attr(name, func(p string) error { return os.Chmod(p, mode) })
+}
+
+func (root rootFiles) Chown(name string, uid, gid int) error {
+	return root.attr(name, func(p string) error { return os.Chown(p, uid, gid) })
+}
+
+func (root rootFiles) Chtimes(name string, atime, mtime time.Time) error {
+	return root.attr(name, func(p string) error { return os.Chtimes(p, atime, mtime) })
+}
+
+func (root rootFiles) Truncate(name string, size int64) error {
+	return root.attr(name, func(p string) error { return os.Truncate(p, size) })
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package ssh
+
+import (
+	"errors"
+)
+
+func newSFTPFiles(root string) (sftpFiles, error) {
+	if root != "" {
+		return nil, errors.New("confining sftp to a root is only supported on linux")
+	}
+
+	return osFiles{}, nil
+}
@@ -0,0 +1,590 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/pkg/sftp"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_sftp_openMode(t *testing.T) {
+	s := &Server{Shell: "sh"}
+	_, client, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if c, err := sftp.NewClient(client); err == nil {
+		c.Close()
+		t.Error("sftp is available in open mode")
+	}
+}
+
+func Test_sftp_openModeAllowed(t *testing.T) {
+	s := &Server{Shell: "sh", AllowSFTPWithoutAuth: true}
+	_, client, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+}
+
+func Test_sftp_withAuth(t *testing.T) {
+	signer, pub := newTestSigner(t)
+	s := &Server{Shell: "sh", AuthorizedKeys: []ssh.PublicKey{pub}}
+	_, client, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+	defer cleanup()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+}
+
+func Test_sftp_disabled(t *testing.T) {
+	signer, pub := newTestSigner(t)
+	s := &Server{Shell: "sh", AuthorizedKeys: []ssh.PublicKey{pub}, DisableSFTP: true}
+	session, client, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+	defer cleanup()
+
+	if c, err := sftp.NewClient(client); err == nil {
+		c.Close()
+		t.Error("sftp is available when disabled")
+	}
+
+	out, err := session.Output("echo hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "hello\n" {
+		t.Errorf("got %q", out)
+	}
+}
+
+func Test_sftp_sftpOnlyKey(t *testing.T) {
+	var tests = []struct {
+		name     string
+		sftpOnly bool
+	}{
+		{name: "sftp-only", sftpOnly: true},
+		{name: "full-access"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, pub := newTestSigner(t)
+			s := &Server{Shell: "sh", AuthorizedKeys: []ssh.PublicKey{pub}}
+			if tt.sftpOnly {
+				s.SFTPOnlyKeys = []ssh.PublicKey{pub}
+			}
+
+			session, client, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+			defer cleanup()
+
+			c, err := sftp.NewClient(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.Close()
+
+			err = session.Run("true")
+			if !tt.sftpOnly {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			exitErr, ok := err.(*gossh.ExitError)
+			if !ok || exitErr.ExitStatus() != ExitCodeRejected {
+				t.Fatalf("expected exit code %d, got %v", ExitCodeRejected, err)
+			}
+		})
+	}
+}
+
+func Test_sftp_modes(t *testing.T) {
+	signer, pub := newTestSigner(t)
+	s := &Server{
+		Shell:               "sh",
+		AuthorizedKeys:      []ssh.PublicKey{pub},
+		SFTPDefaultFileMode: 0666,
+		SFTPDefaultDirMode:  0777,
+		SFTPUmask:           0027,
+	}
+
+	_, client, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+	defer cleanup()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	dir := t.TempDir()
+	f, err := c.Create(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	if err := c.Mkdir(filepath.Join(dir, "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		expected os.FileMode
+	}{
+		{name: "file", expected: 0640},
+		{name: "dir", expected: 0750},
+	}
+
+	for _, tt := range tests {
+		fi, err := os.Stat(filepath.Join(dir, tt.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Mode().Perm() != tt.expected {
+			t.Errorf("%s has mode %o, expected %o", tt.name, fi.Mode().Perm(), tt.expected)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "content" {
+		t.Errorf("got content %q", content)
+	}
+
+	entries, err := c.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("listed %d entries, expected 2", len(entries))
+	}
+}
+
+func Test_sftp_readOnly(t *testing.T) {
+	signer, pub := newTestSigner(t)
+	s := &Server{Shell: "sh", AuthorizedKeys: []ssh.PublicKey{pub}, SFTPReadOnly: true, SFTPUmask: 0027}
+	_, client, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+	defer cleanup()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	if err := ioutil.WriteFile(existing, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := c.Open(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(content) != "content" {
+		t.Fatalf("got %q, %v reading a file", content, err)
+	}
+
+	if entries, err := c.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Fatalf("got %v, %v listing a directory", entries, err)
+	}
+
+	var writes = []struct {
+		name string
+		op   func() error
+	}{
+		{name: "put", op: func() error {
+			f, err := c.Create(filepath.Join(dir, "uploaded"))
+			if err == nil {
+				_, err = f.Write([]byte("content"))
+				f.Close()
+			}
+			return err
+		}},
+		{name: "remove", op: func() error { return c.Remove(existing) }},
+		{name: "rename", op: func() error { return c.Rename(existing, filepath.Join(dir, "renamed")) }},
+		{name: "mkdir", op: func() error { return c.Mkdir(filepath.Join(dir, "dir")) }},
+	}
+
+	for _, w := range writes {
+		if err := w.op(); err == nil {
+			t.Errorf("%s succeeded in read-only mode", w.name)
+		}
+	}
+
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 || entries[0].Name() != "existing" {
+		t.Errorf("the directory was modified: %v", entries)
+	}
+}
+
+func Test_sftp_root(t *testing.T) {
+	root := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(root, "inside"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outside := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, pub := newTestSigner(t)
+	s := &Server{Shell: "sh", AuthorizedKeys: []ssh.PublicKey{pub}, SFTPRoot: root}
+	session, client, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+	defer cleanup()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	entries, err := c.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	if len(names) != 2 || names[0] != "escape" || names[1] != "inside" {
+		t.Errorf("listed %v in /, expected the contents of the root", names)
+	}
+
+	f, err := c.Open("/inside")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(content) != "inside" {
+		t.Fatalf("got %q, %v reading a file in the root", content, err)
+	}
+
+	rel, err := filepath.Rel(root, filepath.Join(outside, "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reads = []struct {
+		name string
+		path string
+	}{
+		{name: "absolute", path: filepath.Join(outside, "secret")},
+		{name: "dot-dot", path: "/" + rel},
+		{name: "relative-dot-dot", path: rel},
+		{name: "symlink", path: "/escape/secret"},
+	}
+
+	for _, tt := range reads {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := c.Open(tt.path)
+			if err != nil {
+				return
+			}
+
+			content, _ := ioutil.ReadAll(f)
+			f.Close()
+			t.Errorf("read %q outside the root", content)
+		})
+	}
+
+	if _, err := c.Create("/escape/uploaded"); err == nil {
+		t.Error("created a file outside the root")
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "uploaded")); !os.IsNotExist(err) {
+		t.Errorf("a file was written outside the root: %v", err)
+	}
+
+	// the shell isn't confined
+	out, err := session.Output("cat " + filepath.Join(outside, "secret"))
+	if err != nil || string(out) != "secret" {
+		t.Errorf("got %q, %v reading outside the root from the shell", out, err)
+	}
+}
+
+func Test_sftp_rootSymlinks(t *testing.T) {
+	root := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(root, "inside"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outside := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// absolute targets are relative to the root, like in a chroot
+	links := map[string]string{
+		"internal": "/inside",
+		"existing": filepath.Join(outside, "secret"),
+		"dangling": filepath.Join(outside, "missing"),
+		"parent":   outside,
+	}
+
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	signer, pub := newTestSigner(t)
+	s := &Server{Shell: "sh", AuthorizedKeys: []ssh.PublicKey{pub}, SFTPRoot: root}
+	_, client, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+	defer cleanup()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	f, err := c.Open("/internal")
+	if err != nil {
+		t.Fatalf("failed to follow a link inside the root: %s", err)
+	}
+
+	f.Close()
+
+	var tests = []struct {
+		name string
+		op   func() error
+	}{
+		{name: "read-existing", op: func() error {
+			f, err := c.Open("/existing")
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{name: "write-existing", op: func() error {
+			f, err := c.OpenFile("/existing", os.O_WRONLY|os.O_TRUNC)
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{name: "create-dangling", op: func() error {
+			f, err := c.Create("/dangling")
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{name: "create-parent", op: func() error {
+			f, err := c.Create("/parent/uploaded")
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{name: "mkdir-parent", op: func() error { return c.Mkdir("/parent/dir") }},
+		{name: "list-parent", op: func() error {
+			_, err := c.ReadDir("/parent")
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); err == nil {
+				t.Error("escaped the root")
+			}
+		})
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(outside, "secret")); err != nil || string(content) != "secret" {
+		t.Errorf("got %q, %v reading the file outside the root", content, err)
+	}
+
+	if entries, _ := ioutil.ReadDir(outside); len(entries) != 1 {
+		t.Errorf("the directory outside the root was modified: %v", entries)
+	}
+}
+
+func Test_sftp_modesPaths(t *testing.T) {
+	signer, pub := newTestSigner(t)
+	s := &Server{Shell: "sh", AuthorizedKeys: []ssh.PublicKey{pub}, SFTPUmask: 0027}
+	_, client, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+	defer cleanup()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := c.Getwd(); err != nil || got != wd {
+		t.Errorf("got %q, %v as the start directory, expected %q", got, err, wd)
+	}
+
+	// relative to the start directory
+	dir, err := ioutil.TempDir(".", "sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	f, err := c.Create(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+	if _, err := os.Stat(filepath.Join(wd, dir, "file")); err != nil {
+		t.Errorf("the relative path wasn't created in the start directory: %s", err)
+	}
+
+	link := filepath.Join(dir, "link")
+	if err := c.Symlink("file", link); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, err := os.Readlink(link); err != nil || target != "file" {
+		t.Errorf("got %q, %v as the link target, expected it as sent", target, err)
+	}
+
+	fi, err := c.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("lstat followed the link: %s", fi.Mode())
+	}
+
+	if fi, err := c.Stat(link); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("got %v, %v from stat, expected the target", fi, err)
+	}
+}
+
+func Test_sftp_rootRace(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(root, "d", "f"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outside := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(outside, "f"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, pub := newTestSigner(t)
+	s := &Server{Shell: "sh", AuthorizedKeys: []ssh.PublicKey{pub}, SFTPRoot: root}
+	_, client, cleanup := newTestSession(t, s.getServer(), clientConfigWithKey(signer))
+	defer cleanup()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	// swap the directory with a link out of the root while it's being read
+	stop := make(chan struct{})
+	swapped := make(chan struct{})
+	go func() {
+		defer close(swapped)
+		d := filepath.Join(root, "d")
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			os.Rename(d, d+".real")
+			os.Symlink(outside, d)
+			os.Remove(d)
+			os.Rename(d+".real", d)
+		}
+	}()
+
+	// the requests are served concurrently
+	var wg sync.WaitGroup
+	var escaped int32
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				f, err := c.Open("/d/f")
+				if err != nil {
+					continue
+				}
+
+				content, _ := ioutil.ReadAll(f)
+				f.Close()
+				if string(content) == "secret" {
+					atomic.AddInt32(&escaped, 1)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+	<-swapped
+	if n := atomic.LoadInt32(&escaped); n > 0 {
+		t.Errorf("read a file outside the root %d times", n)
+	}
+}
@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const passwdPath = "/etc/passwd"
+
+// ShellResolver returns the shell used for the sessions of user
+type ShellResolver func(user string) (string, error)
+
+// PasswdShell returns the login shell of user in /etc/passwd. It can be used
+// as a ShellResolver.
+func PasswdShell(user string) (string, error) {
+	return passwdShell(passwdPath, user)
+}
+
+func passwdShell(path, user string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) != 7 || fields[0] != user {
+			continue
+		}
+
+		if fields[6] == "" {
+			return "", fmt.Errorf("%s has no login shell", user)
+		}
+
+		return fields[6], nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("%s is not in %s", user, path)
+}
+
+// shellFor returns the shell for the sessions of user, falling back to Shell
+// when there's no resolver or it fails
+func (srv *Server) shellFor(user string) string {
+	if srv.ShellResolver == nil {
+		return srv.Shell
+	}
+
+	shell, err := srv.ShellResolver(user)
+	if err != nil || shell == "" {
+		log.WithError(err).Warningf("failed to resolve the shell of %s, using %s", user, srv.Shell)
+		return srv.Shell
+	}
+
+	return shell
+}
+
+// shellSubsystem returns the name of the subsystem that runs shell
+func shellSubsystem(shell string) string {
+	return "shell:" + filepath.Base(shell)
+}
+
+func (srv *Server) validateShellSubsystems() error {
+	for _, shell := range srv.ShellSubsystems {
+		if _, err := exec.LookPath(shell); err != nil {
+			return fmt.Errorf("invalid shell subsystem %s: %w", shell, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_passwdShell(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	passwd := "root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/zsh\nnoshell:x:1001:1001::/home/noshell:\n"
+	if err := ioutil.WriteFile(path, []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	shell, err := passwdShell(path, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shell != "/bin/zsh" {
+		t.Errorf("got %s, expected /bin/zsh", shell)
+	}
+
+	if _, err := passwdShell(path, "noshell"); err == nil {
+		t.Error("user without shell didn't fail")
+	}
+
+	if _, err := passwdShell(path, "bob"); err == nil {
+		t.Error("missing user didn't fail")
+	}
+}
+
+func Test_buildCmd_shellResolver(t *testing.T) {
+	var tests = []struct {
+		user     string
+		expected string
+	}{
+		{user: "alice", expected: "/bin/sh"},
+		{user: "bob", expected: "sh"},
+		{user: "broken", expected: "sh"},
+	}
+
+	s := &Server{
+		Shell: "sh",
+		ShellResolver: func(user string) (string, error) {
+			switch user {
+			case "alice":
+				return "/bin/sh", nil
+			case "broken":
+				return "", errors.New("resolver failed")
+			default:
+				return "sh", nil
+			}
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			session, _, cleanup := newTestSession(t, s.getServer(), &gossh.ClientConfig{User: tt.user})
+			defer cleanup()
+
+			var stdout bytes.Buffer
+			session.Stdout = &stdout
+			if err := session.Run("echo $0"); err != nil {
+				t.Fatal(err)
+			}
+
+			if out := strings.TrimSpace(stdout.String()); out != tt.expected {
+				t.Errorf("got shell %s, expected %s", out, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_shellSubsystems(t *testing.T) {
+	zsh := filepath.Join(t.TempDir(), "zsh")
+	if err := ioutil.WriteFile(zsh, []byte("#!/bin/sh\necho zsh-stub\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Shell: "sh", ShellSubsystems: []string{zsh}}
+	if err := s.validateShellSubsystems(); err != nil {
+		t.Fatal(err)
+	}
+
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.RequestSubsystem(shellSubsystem(zsh)); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "zsh-stub\n" {
+		t.Errorf("got %q, expected the output of the zsh stub", out)
+	}
+}
+
+func Test_validateShellSubsystems(t *testing.T) {
+	s := &Server{ShellSubsystems: []string{"sh", filepath.Join(t.TempDir(), "missing")}}
+	if err := s.validateShellSubsystems(); err == nil {
+		t.Error("missing shell was accepted")
+	}
+}
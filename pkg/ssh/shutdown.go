@@ -0,0 +1,63 @@
+package ssh
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrServerClosed is returned by ListenAndServe after a call to Shutdown
+var ErrServerClosed = ssh.ErrServerClosed
+
+// Shutdown stops accepting connections and waits for the open ones to close.
+// The new sessions of the open connections are rejected. When ctx expires
+// first, the commands of the remaining sessions are terminated and the error
+// of ctx is returned. The admin socket and the health endpoint are closed
+// and the readiness probe stops once it returns.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	server := srv.server
+	listeners := []net.Listener{srv.adminListener, srv.healthListener}
+	stopReadiness := srv.stopReadiness
+	srv.stopReadiness = nil
+	srv.shuttingDown = true
+	srv.mu.Unlock()
+
+	defer func() {
+		for _, l := range listeners {
+			if l != nil {
+				l.Close()
+			}
+		}
+
+		if stopReadiness != nil {
+			close(stopReadiness)
+		}
+	}()
+
+	if server == nil {
+		return nil
+	}
+
+	err := server.Shutdown(ctx)
+	if ctx.Err() != nil {
+		var wg sync.WaitGroup
+		srv.sessions.Range(func(id, s interface{}) bool {
+			log.WithField("session.id", id).Info("shutdown grace period expired, terminating the session")
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.(*activeSession).terminate(EndReasonShutdown)
+			}()
+
+			return true
+		})
+
+		wg.Wait()
+	}
+
+	return err
+}
@@ -0,0 +1,198 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// startServer runs s with ListenAndServe on a free local port and returns
+// its address once it accepts connections
+func startServer(t *testing.T, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.BindAddress = "127.0.0.1"
+	s.Port = l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	go s.ListenAndServe()
+	addr := net.JoinHostPort(s.BindAddress, strconv.Itoa(s.Port))
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return addr
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatal("server didn't start")
+	return ""
+}
+
+func Test_Shutdown(t *testing.T) {
+	s := &Server{Shell: "sh"}
+	addr := startServer(t, s)
+
+	session, _, cleanup := newClientSession(t, addr, nil)
+	defer cleanup()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Start("sleep 1; echo done"); err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	refused := false
+	for i := 0; i < 50 && !refused; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			refused = true
+			break
+		}
+
+		conn.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !refused {
+		t.Error("new connections were accepted after Shutdown")
+	}
+
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout.String() != "done\n" {
+		t.Errorf("got %q, the command didn't finish", stdout.String())
+	}
+
+	cleanup()
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown didn't return after the connection closed")
+	}
+}
+
+func Test_Shutdown_gracePeriod(t *testing.T) {
+	s := &Server{Shell: "sh"}
+	addr := startServer(t, s)
+
+	session, _, cleanup := newClientSession(t, addr, nil)
+	defer cleanup()
+
+	if err := session.Start("sleep 30"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50 && len(s.ActiveSessions()) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, expected the grace period to expire", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err := <-done:
+		if _, ok := err.(*gossh.ExitError); !ok {
+			t.Errorf("got %v, expected the command to be terminated", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("command wasn't terminated after the grace period")
+	}
+}
+
+func Test_Shutdown_terminatesConcurrently(t *testing.T) {
+	s := &Server{Shell: "sh", KillGrace: time.Second}
+	addr := startServer(t, s)
+
+	// the commands ignore SIGTERM, so each one takes the whole KillGrace
+	for i := 0; i < 2; i++ {
+		session, _, cleanup := newClientSession(t, addr, nil)
+		defer cleanup()
+
+		if err := session.Start("trap '' TERM; sleep 30"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 50 && len(s.ActiveSessions()) < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, expected the grace period to expire", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 1800*time.Millisecond {
+		t.Errorf("terminating the sessions took %s, they weren't terminated concurrently", elapsed)
+	}
+}
+
+func Test_Shutdown_closesAdminSocket(t *testing.T) {
+	s := &Server{Shell: "sh", AdminSocketPath: filepath.Join(t.TempDir(), "admin.sock")}
+	startServer(t, s)
+
+	adminCommand(t, s.AdminSocketPath, "LIST")
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if conn, err := net.Dial("unix", s.AdminSocketPath); err == nil {
+		conn.Close()
+		t.Error("admin socket is still listening after Shutdown")
+	}
+}
+
+func Test_Shutdown_stopsReadinessProbe(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	s := &Server{Shell: "sh", ReadinessCommand: "echo >> " + runs, ReadinessInterval: 10 * time.Millisecond}
+	startServer(t, s)
+	waitForReady(t, s, true)
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a run may have been in progress
+	time.Sleep(50 * time.Millisecond)
+	before, err := ioutil.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	after, err := ioutil.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(after) != len(before) {
+		t.Errorf("the readiness command ran %d times after Shutdown", len(after)-len(before))
+	}
+}
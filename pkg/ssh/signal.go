@@ -0,0 +1,90 @@
+package ssh
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+var signals = map[ssh.Signal]syscall.Signal{
+	ssh.SIGABRT: syscall.SIGABRT,
+	ssh.SIGALRM: syscall.SIGALRM,
+	ssh.SIGFPE:  syscall.SIGFPE,
+	ssh.SIGHUP:  syscall.SIGHUP,
+	ssh.SIGILL:  syscall.SIGILL,
+	ssh.SIGINT:  syscall.SIGINT,
+	ssh.SIGKILL: syscall.SIGKILL,
+	ssh.SIGPIPE: syscall.SIGPIPE,
+	ssh.SIGQUIT: syscall.SIGQUIT,
+	ssh.SIGSEGV: syscall.SIGSEGV,
+	ssh.SIGTERM: syscall.SIGTERM,
+	ssh.SIGUSR1: syscall.SIGUSR1,
+	ssh.SIGUSR2: syscall.SIGUSR2,
+}
+
+// signalProcessGroup sends sig to the process group led by cmd, so children
+// spawned by the shell get it too.
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
+
+// forwardedSignals records the signals the client sent to the process group of
+// a command, which reach the shell leading it too
+type forwardedSignals struct {
+	mu   sync.Mutex
+	sent map[syscall.Signal]bool
+}
+
+func (f *forwardedSignals) add(sig syscall.Signal) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sent == nil {
+		f.sent = map[syscall.Signal]bool{}
+	}
+
+	f.sent[sig] = true
+}
+
+// has returns true if the client sent sig
+func (f *forwardedSignals) has(sig syscall.Signal) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sent[sig]
+}
+
+// forwardSignals relays the signals sent by the client to the process group of
+// cmd, recording them in forwarded, until the returned function is called.
+func forwardSignals(logger *log.Entry, cmd *exec.Cmd, s ssh.Session, forwarded *forwardedSignals) func() {
+	sigCh := make(chan ssh.Signal, 1)
+	done := make(chan struct{})
+	s.Signals(sigCh)
+
+	go func() {
+		for {
+			select {
+			case sig := <-sigCh:
+				sysSig, ok := signals[sig]
+				if !ok {
+					logger.Infof("ignoring unknown signal %s", sig)
+					continue
+				}
+
+				logger.Infof("forwarding signal %s", sig)
+				forwarded.add(sysSig)
+				if err := signalProcessGroup(cmd, sysSig); err != nil {
+					logger.WithError(err).Errorf("failed to send signal %s", sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		s.Signals(nil)
+		close(done)
+	}
+}
@@ -0,0 +1,45 @@
+package ssh
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_handleNoTTY_signal(t *testing.T) {
+	logs := captureLogs(t)
+	s := &Server{Shell: "sh"}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Start("sleep 10"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if err := session.Signal(gossh.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- session.Wait()
+	}()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("terminated command didn't fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("command didn't terminate after SIGTERM")
+	}
+
+	// the client asked for it, the shell didn't crash
+	waitForLog(t, logs, "session closed")
+	if strings.Contains(logs.String(), "shell.crashed") {
+		t.Errorf("a forwarded signal was logged as a crash:\n%s", logs.String())
+	}
+}
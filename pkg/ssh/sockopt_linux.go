@@ -0,0 +1,13 @@
+package ssh
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func setReusePort(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+}
+
+func setTCPFastOpen(fd uintptr, queueLen int) error {
+	return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, queueLen)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package ssh
+
+func setReusePort(fd uintptr) error {
+	return errSockoptUnsupported
+}
+
+func setTCPFastOpen(fd uintptr, queueLen int) error {
+	return errSockoptUnsupported
+}
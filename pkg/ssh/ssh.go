@@ -1,14 +1,22 @@
 package ssh
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
@@ -16,13 +24,39 @@ import (
 	"github.com/creack/pty"
 	"github.com/gliderlabs/ssh"
 	"github.com/google/uuid"
-	"github.com/pkg/sftp"
 	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// ExitCodeInternalError is the exit code sent to the client when the
+	// server, not the command, fails. It matches OpenSSH.
+	ExitCodeInternalError = 255
+
+	// ExitCodeRejected is the exit code sent to the client when the server
+	// refuses to run the session because of its configuration
+	ExitCodeRejected = 254
+
+	// ExitCodeBusy is the exit code sent to the client when LoadShedFunc
+	// refuses the session, so it can retry later
+	ExitCodeBusy = 253
+
+	// ExitCodeTimeout is the default exit code sent to the client when the
+	// command of a PTY session is terminated by SessionIdleTimeout, see
+	// TimeoutExitCode
+	ExitCodeTimeout = 252
+
+	preCloseTimeout = 30 * time.Second
+
+	scratchDirEnv = "OKTETO_SCRATCH"
 )
 
 var (
 	// ErrEOF is the error when the terminal exits
 	ErrEOF = errors.New("EOF")
+
+	// newAgentListener is replaced in tests
+	newAgentListener = ssh.NewAgentListener
 )
 
 // Server holds the ssh server configuration
@@ -30,6 +64,389 @@ type Server struct {
 	Port           int
 	Shell          string
 	AuthorizedKeys []ssh.PublicKey
+
+	// BindAddress is the address the server listens on. All the interfaces
+	// are used when it's empty.
+	BindAddress string
+
+	// TrustedUserCAKeys are the CAs whose user certificates are accepted when
+	// their principals include the user, as an alternative to AuthorizedKeys
+	TrustedUserCAKeys []ssh.PublicKey
+
+	// Password grants access to the clients that send it. Either a password
+	// or an authorized key are enough when both are configured.
+	Password string
+
+	// StrictModes refuses to load an authorized_keys file that others can
+	// write to, instead of only logging a warning
+	StrictModes bool
+
+	// AuthorizedKeysPath is the authorized_keys file read by
+	// LoadAuthorizedKeysFile. Defaults to DefaultAuthorizedKeysPath.
+	AuthorizedKeysPath string
+
+	// AllowedEnv holds the names (or glob patterns) of the variables clients
+	// can set. The rest are dropped. All of them are allowed when it's nil.
+	AllowedEnv []string
+
+	// QuietRoutine logs the start and the normal end of sessions at the debug
+	// level, instead of info. Rejections, errors and timeouts aren't affected.
+	QuietRoutine bool
+
+	// LogFieldNames renames the fields of the session logs, from the default
+	// name (e.g. remote.address) to the one expected downstream (e.g. src_ip)
+	LogFieldNames map[string]string
+
+	// PerSessionLogDir writes the logs of every session to a file of its own
+	// in this directory, named after the user and the session id. The start
+	// and the end of sessions are still logged by the main logger.
+	PerSessionLogDir string
+
+	// LogRedactPatterns mask secrets in the commands and outputs that are
+	// logged. DefaultLogRedactPatterns are used when it's nil, and an empty
+	// list disables redaction.
+	LogRedactPatterns []*regexp.Regexp
+
+	// EnvLogDenylist holds the names (or glob patterns) of the variables whose
+	// values are never logged
+	EnvLogDenylist []string
+
+	// SFTPReadOnly refuses every sftp request that writes, so clients can only
+	// browse and download files
+	SFTPReadOnly bool
+
+	// DisableSFTP turns off the sftp subsystem, sessions can still run
+	// commands and shells
+	DisableSFTP bool
+
+	// SFTPRoot confines sftp to this directory: the paths of the requests are
+	// resolved as if it was /, so .. and absolute symlinks stay inside it. It's
+	// only supported on linux. The commands run by sessions can still access
+	// the whole filesystem.
+	SFTPRoot string
+
+	// AllowSFTPWithoutAuth enables the sftp subsystem when the server runs
+	// without authentication. By default sftp requires authentication.
+	AllowSFTPWithoutAuth bool
+
+	// ServerConfigCallback customizes the crypto/ssh server configuration of
+	// each connection (ciphers, key exchanges, MaxAuthTries...). The channel
+	// window and packet sizes are fixed by crypto/ssh and can't be tuned here.
+	ServerConfigCallback ssh.ServerConfigCallback
+
+	// PreCloseCommand is run with the shell when a session ends, to clean up
+	// after it. Its output is logged and never sent to the client.
+	PreCloseCommand string
+
+	// ReusePort sets SO_REUSEPORT on the listener, so several server
+	// processes can share the port. It's ignored with a warning where it's
+	// not supported.
+	ReusePort bool
+
+	// TCPFastOpen enables TCP Fast Open on the listener where supported
+	TCPFastOpen bool
+
+	// PTYSeparateStderr sends the stderr of PTY sessions over the extended
+	// data stream instead of the terminal. Interactive shells write their
+	// prompt to stderr, so this is meant for tools that need the split.
+	PTYSeparateStderr bool
+
+	// RecordingSink enables the recording of PTY sessions in the asciicast v2
+	// format, written to the writer it returns for each session
+	RecordingSink RecordingSink
+
+	// SessionRecordDir records PTY sessions to <session id>.cast files in
+	// this directory, when RecordingSink isn't set
+	SessionRecordDir string
+
+	// ExecPrefix is prepended to the commands of exec sessions (e.g. time or
+	// strace -f). Interactive shells aren't affected.
+	ExecPrefix string
+
+	// HostKeyPath is the PEM encoded host key. A new key is generated there if
+	// it doesn't exist, unless HostKeyPassphrase or HostKeyWait are set. The
+	// embedded key is used when it and HostKeys are empty.
+	HostKeyPath string
+
+	// HostKeyFallbackToEmbedded uses the embedded host key when the key at
+	// HostKeyPath can't be loaded or generated, instead of failing to start.
+	// Clients can't tell servers using the embedded key apart.
+	HostKeyFallbackToEmbedded bool
+
+	// HostKeys are PEM encoded host keys served along with the one at
+	// HostKeyPath, so the server can offer several algorithms
+	HostKeys [][]byte
+
+	// HostKeyPassphrase decrypts the host key at HostKeyPath
+	HostKeyPassphrase string
+
+	// HostKeyWait is how long to wait for a valid key at HostKeyPath, for
+	// when it's written by another process while the server starts
+	HostKeyWait time.Duration
+
+	// MaxCommandLength is the maximum length of the command of a session.
+	// Zero means no limit.
+	MaxCommandLength int
+
+	// AliveInterval is how often keepalive requests are sent while a session
+	// is running, so intermediaries don't close idle connections. Zero
+	// disables them.
+	AliveInterval time.Duration
+
+	// KeepAliveInterval is how often keepalive requests that want a reply are
+	// sent on every connection, including forward-only and sftp ones, to
+	// detect dead clients, e.g. behind a NAT that dropped the connection. The
+	// connection is closed after KeepAliveCountMax of them in a row aren't
+	// answered within the interval. Zero disables them.
+	KeepAliveInterval time.Duration
+
+	// KeepAliveCountMax is how many keepalives in a row can go unanswered
+	// before the connection is closed. Defaults to 3.
+	KeepAliveCountMax int
+
+	// MaxCols and MaxRows limit the terminal size requested by PTY sessions.
+	// Zero means no limit.
+	MaxCols int
+	MaxRows int
+
+	// MaxEnvSize is the maximum size in bytes of the environment sent by the
+	// client. Zero means no limit.
+	MaxEnvSize int
+
+	// MaxEnvVars is the maximum number of environment variables sent by the
+	// client. Sessions over it are rejected, unless DropExtraEnvVars is set.
+	// Zero means no limit.
+	MaxEnvVars int
+
+	// DropExtraEnvVars runs the sessions over MaxEnvVars with only the first
+	// MaxEnvVars variables, instead of rejecting them
+	DropExtraEnvVars bool
+
+	// DisableInteractiveShell refuses sessions without a command, so only exec
+	// sessions are allowed
+	DisableInteractiveShell bool
+
+	// ScratchDirBase is where a temporary directory is created for each
+	// session. Its path is exported as $OKTETO_SCRATCH, and it's removed when
+	// the session ends.
+	ScratchDirBase string
+
+	// DisablePTY refuses pty requests at the channel level, so sessions run
+	// without a terminal. The session channel always accepts env and signal
+	// requests.
+	DisablePTY bool
+
+	// DisableLocalForwarding refuses the direct-tcpip channels used by
+	// clients to forward local ports through the server
+	DisableLocalForwarding bool
+
+	// DisableReverseForwarding refuses the tcpip-forward requests used by
+	// clients to forward ports of the server back to them
+	DisableReverseForwarding bool
+
+	// MaxForwardBytesPerSec limits the data that flows through the local and
+	// reverse forwards of a connection, in both directions and across all of
+	// them. Zero means no limit.
+	MaxForwardBytesPerSec int64
+
+	// ForwardAllowlist holds the only destinations of local forwards, and
+	// addresses of reverse binds, that are allowed. Entries are host:port,
+	// where host can be a CIDR and both can be glob patterns (e.g.
+	// 10.0.0.0/8:5432 or *.svc.cluster.local:*), or a CIDR or host with any
+	// port. Nil allows everything.
+	ForwardAllowlist []string
+
+	// Version identifies the server build in the info@okteto reply
+	Version string
+
+	// NormalizeCRLF converts CRLF line endings sent by the client to LF in
+	// the stdin of non-PTY sessions. PTY sessions are always raw.
+	NormalizeCRLF bool
+
+	// ShellResolver picks the shell of each user. Shell is used when it's nil
+	// or fails.
+	ShellResolver ShellResolver
+
+	// ReapOrphans makes the server a subreaper that collects the orphaned
+	// processes of its sessions. It's always enabled when running as PID 1.
+	ReapOrphans bool
+
+	// FirstOutputTimeout logs a warning when a non-interactive command doesn't
+	// write to stdout or stderr within the duration. Zero disables it.
+	FirstOutputTimeout time.Duration
+
+	// KillOnFirstOutputTimeout kills the commands that exceed FirstOutputTimeout
+	KillOnFirstOutputTimeout bool
+
+	// SFTPOnlyKeys are the keys of AuthorizedKeys that can only use the sftp
+	// subsystem. Connections that offer one of them can't run commands.
+	SFTPOnlyKeys []ssh.PublicKey
+
+	// DisableServerEnv starts sessions without the environment of the server
+	// process, only with the client variables and the login defaults
+	DisableServerEnv bool
+
+	// SessionIdleTimeout kills the command of a session when neither the
+	// client nor the command send any data for the duration. Zero disables it.
+	SessionIdleTimeout time.Duration
+
+	// TimeoutExitCode is the exit code sent to PTY clients when
+	// SessionIdleTimeout terminates their command, after a message on the
+	// terminal, so scripts driving a terminal can tell timeouts apart from
+	// the command failing. Zero means ExitCodeTimeout, a timeout is never
+	// reported as a success. Sessions without a PTY get the exit status of
+	// the terminated command, and connections closed by IdleTimeout can't be
+	// sent an exit status.
+	TimeoutExitCode int
+
+	// AllowedSubsystems holds the names (or glob patterns) of the only
+	// subsystems clients can request, out of the ones enabled. All of them
+	// are allowed when it's empty.
+	AllowedSubsystems []string
+
+	// EnablePing serves the ping subsystem, which answers "pong" without
+	// running a command
+	EnablePing bool
+
+	// KillGrace is how long commands have to exit after SIGTERM before they
+	// are sent SIGKILL when a session is torn down. Zero sends SIGKILL
+	// right away.
+	KillGrace time.Duration
+
+	// AccessLog receives a single line per session with its remote IP, user,
+	// command, exit code and duration. See accessLogLine for the format.
+	AccessLog io.Writer
+
+	// AuditLogPath is a file where a JSON record of every session is
+	// appended, with its command and exit code. See AuditRecord.
+	AuditLogPath string
+
+	// RefuseRoot makes ListenAndServe fail when the server runs as root
+	// instead of only logging a warning
+	RefuseRoot bool
+
+	// SFTPDefaultFileMode is the mode of the files created through sftp,
+	// before SFTPUmask is applied. Defaults to 0644.
+	SFTPDefaultFileMode os.FileMode
+
+	// SFTPDefaultDirMode is the mode of the directories created through sftp,
+	// before SFTPUmask is applied. Defaults to 0755.
+	SFTPDefaultDirMode os.FileMode
+
+	// SFTPUmask is applied to the modes of the files and directories created
+	// through sftp instead of the process umask.
+	SFTPUmask os.FileMode
+
+	// SessionBufferBytes keeps the last bytes of the output of each active
+	// session in memory, see SessionOutputs and the OUTPUT command of the
+	// admin socket. Zero disables it.
+	SessionBufferBytes int
+
+	// IdleTimeout closes connections without any traffic for the duration.
+	// Zero means no timeout.
+	IdleTimeout time.Duration
+
+	// Banner is shown to clients before they authenticate, e.g. an
+	// authorized-use notice. See LoadBanner.
+	Banner string
+
+	// OpenBanner is shown instead of Banner when authentication is disabled.
+	// Defaults to DefaultOpenBanner.
+	OpenBanner string
+
+	// ShellSubsystems are shells clients can pick with the shell:<name>
+	// subsystem, where name is the base name of the shell
+	ShellSubsystems []string
+
+	// ReadTimeout is the longest a single read from a connection can block.
+	// Unlike IdleTimeout, it's not extended by the data the server sends, so
+	// interactive clients need keepalives to stay under it. Zero disables it.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is the longest a single write to a connection can block,
+	// which drops clients that stop reading. Zero disables it.
+	WriteTimeout time.Duration
+
+	// EnvSanitizer receives the environment of every command right before
+	// it starts, with the server, client, login and session variables such
+	// as TERM or SSH_AUTH_SOCK, and returns the one the command runs with,
+	// e.g. to strip SUDO_* or rewrite PATH.
+	EnvSanitizer func([]string) []string
+
+	// AuthorizeSession is called for every authenticated session before its
+	// command runs. When it returns false, the session is rejected and the
+	// reason is written to its stderr.
+	AuthorizeSession func(SessionInfo) (bool, string)
+
+	// LoadShedFunc is called before every session, and the session is
+	// rejected with ExitCodeBusy when it returns true. The sessions already
+	// running aren't affected.
+	LoadShedFunc func() bool
+
+	// MaxSessions is the most sessions that can run at once, over all the
+	// connections. Sessions over it are rejected. Zero means no limit.
+	MaxSessions int
+
+	// RequireAgentForwarding ends the sessions that request agent forwarding
+	// when the agent can't be started. Otherwise they run without it.
+	RequireAgentForwarding bool
+
+	// HealthPort serves /healthz and /readyz over HTTP, for probes that
+	// shouldn't open an SSH connection, and /statusz with the session,
+	// rejection and forward counts and the host key fingerprints. Zero
+	// disables it.
+	HealthPort int
+
+	// AdminSocketPath is a unix socket where local processes can list the
+	// active sessions and kill them, see handleAdmin
+	AdminSocketPath string
+
+	// ReadinessCommand is run every ReadinessInterval, and the server is only
+	// reported as ready while it succeeds. See Ready.
+	ReadinessCommand string
+
+	// ReadinessInterval is how often ReadinessCommand runs, 10s by default
+	ReadinessInterval time.Duration
+
+	// EventSocketPath is a unix socket where session events are published as JSON
+	EventSocketPath string
+
+	events          *eventEmitter
+	hostSigners     []gossh.Signer
+	sessionBuffers  sync.Map
+	sessions        sync.Map
+	openSessions    int64
+	ready           int32
+	accessLogFailed sync.Once
+
+	localForwards    uint64
+	reverseForwards  uint64
+	activeForwards   int64
+	reverseListeners sync.Map
+	forwardLimiters  sync.Map
+	rejections       sync.Map
+
+	mu             sync.Mutex
+	server         *ssh.Server
+	adminListener  net.Listener
+	healthListener net.Listener
+	stopReadiness  chan struct{}
+	shuttingDown   bool
+}
+
+// exitSignal returns the signal that killed the command that returned err
+func exitSignal(err error) (syscall.Signal, bool) {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0, false
+	}
+
+	waitStatus, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !waitStatus.Signaled() {
+		return 0, false
+	}
+
+	return waitStatus.Signal(), true
 }
 
 func getExitStatusFromError(err error) int {
@@ -39,7 +456,7 @@ func getExitStatusFromError(err error) int {
 
 	exitErr, ok := err.(*exec.ExitError)
 	if !ok {
-		return 1
+		return ExitCodeInternalError
 	}
 
 	waitStatus, ok := exitErr.Sys().(syscall.WaitStatus)
@@ -51,6 +468,10 @@ func getExitStatusFromError(err error) int {
 		return 1
 	}
 
+	if waitStatus.Signaled() {
+		return 128 + int(waitStatus.Signal())
+	}
+
 	return waitStatus.ExitStatus()
 }
 
@@ -59,20 +480,50 @@ func setWinsize(f *os.File, w, h int) {
 		uintptr(unsafe.Pointer(&struct{ h, w, x, y uint16 }{uint16(h), uint16(w), 0, 0})))
 }
 
-func handlePTY(logger *log.Entry, cmd *exec.Cmd, s ssh.Session, ptyReq ssh.Pty, winCh <-chan ssh.Window) error {
-	if len(ptyReq.Term) > 0 {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("TERM=%s", ptyReq.Term))
+// clampWindow limits the size requested by the client to MaxCols and MaxRows
+func (srv *Server) clampWindow(win ssh.Window) (int, int) {
+	return clamp(win.Width, srv.MaxCols), clamp(win.Height, srv.MaxRows)
+}
+
+func clamp(v, max int) int {
+	if max <= 0 || max > math.MaxUint16 {
+		max = math.MaxUint16
 	}
 
-	f, err := pty.Start(cmd)
+	if v > max {
+		return max
+	}
+
+	if v < 0 {
+		return 0
+	}
+
+	return v
+}
+
+func (srv *Server) handlePTY(logger *log.Entry, info SessionInfo, active *activeSession, cmd *exec.Cmd, s ssh.Session, ptyReq ssh.Pty, winCh <-chan ssh.Window) error {
+	if srv.PTYSeparateStderr {
+		cmd.Stderr = s.Stderr()
+	}
+
+	width, height := srv.clampWindow(ptyReq.Window)
+	var f *os.File
+	err := startTracked(cmd, func() error {
+		var err error
+		f, err = pty.StartWithSize(cmd, &pty.Winsize{Cols: uint16(width), Rows: uint16(height)})
+		return err
+	})
 	if err != nil {
 		logger.WithError(err).Error("failed to start pty session")
 		return err
 	}
 
+	active.started()
+
 	go func() {
 		for win := range winCh {
-			setWinsize(f, win.Width, win.Height)
+			width, height := srv.clampWindow(win)
+			setWinsize(f, width, height)
 		}
 	}()
 
@@ -80,20 +531,26 @@ func handlePTY(logger *log.Entry, cmd *exec.Cmd, s ssh.Session, ptyReq ssh.Pty,
 		io.Copy(f, s) // stdin
 	}()
 
+	var stdout io.Writer = s
+	if rec := srv.startRecording(logger, info, width, height, ptyReq.Term); rec != nil {
+		defer rec.Close()
+		stdout = io.MultiWriter(s, rec)
+	}
+
 	waitCh := make(chan struct{})
 	go func() {
 		defer close(waitCh)
-		io.Copy(s, f) // stdout
+		io.Copy(stdout, f) // stdout
 	}()
 
-	if err := cmd.Wait(); err != nil {
+	if err := waitTracked(cmd); err != nil {
 		logger.WithError(err).Errorf("pty command failed while waiting")
 		return err
 	}
 
 	select {
 	case <-waitCh:
-		logger.Info("stdout finished")
+		logger.Log(srv.routineLevel(), "stdout finished")
 	case <-time.NewTicker(1 * time.Second).C:
 		logger.Info("stdout didn't finish after 1s")
 	}
@@ -112,7 +569,27 @@ func sendErrAndExit(logger *log.Entry, s ssh.Session, err error) {
 	}
 }
 
-func handleNoTTY(logger *log.Entry, cmd *exec.Cmd, s ssh.Session) error {
+func rejectSession(logger *log.Entry, s ssh.Session, msg string) {
+	rejectSessionWithCode(logger, s, msg, ExitCodeRejected)
+}
+
+func rejectSessionWithCode(logger *log.Entry, s ssh.Session, msg string, code int) {
+	logger.Infof("session rejected: %s", msg)
+	if _, err := fmt.Fprintln(s.Stderr(), msg); err != nil {
+		logger.WithError(err).Errorf("failed to write error back to session")
+	}
+
+	if err := s.Exit(code); err != nil {
+		logger.WithError(err).Errorf("session failed to exit")
+	}
+}
+
+// handleNoTTY runs cmd with its stdio connected to the session. The stdin of
+// the command is closed once the client sends EOF, so commands reading a
+// finite input supplied by the client (e.g. `ssh host wc -c < file`) consume
+// it fully and exit. The signals sent by the client are forwarded to the
+// command and recorded in forwarded.
+func (srv *Server) handleNoTTY(logger *log.Entry, active *activeSession, cmd *exec.Cmd, s ssh.Session, forwarded *forwardedSignals) error {
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		logger.WithError(err).Errorf("couldn't get StdoutPipe")
@@ -131,14 +608,33 @@ func handleNoTTY(logger *log.Entry, cmd *exec.Cmd, s ssh.Session) error {
 		return err
 	}
 
-	if err = cmd.Start(); err != nil {
-		logger.WithError(err).Errorf("couldn't start command '%s'", cmd.String())
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err = startTracked(cmd, cmd.Start); err != nil {
+		logger.WithError(err).Errorf("couldn't start command '%s'", srv.redact(cmd.String()))
 		return err
 	}
 
+	active.started()
+
+	stopSignals := forwardSignals(logger, cmd, s, forwarded)
+	defer stopSignals()
+
+	var out, errOut io.Writer = s, s.Stderr()
+	if srv.FirstOutputTimeout > 0 {
+		watch := newOutputWatch()
+		out, errOut = watch.wrap(out), watch.wrap(errOut)
+		stopWatch := srv.watchFirstOutput(logger, cmd, watch)
+		defer stopWatch()
+	}
+
+	var in io.Reader = s
+	if srv.NormalizeCRLF {
+		in = newCRLFReader(s)
+	}
+
 	go func() {
 		defer stdin.Close()
-		if _, err := io.Copy(stdin, s); err != nil {
+		if _, err := io.Copy(stdin, in); err != nil {
 			logger.WithError(err).Errorf("failed to write session to stdin.")
 		}
 	}()
@@ -147,7 +643,7 @@ func handleNoTTY(logger *log.Entry, cmd *exec.Cmd, s ssh.Session) error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if _, err := io.Copy(s, stdout); err != nil {
+		if _, err := io.Copy(out, stdout); err != nil {
 			logger.WithError(err).Errorf("failed to write stdout to session.")
 		}
 	}()
@@ -155,14 +651,14 @@ func handleNoTTY(logger *log.Entry, cmd *exec.Cmd, s ssh.Session) error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if _, err := io.Copy(s.Stderr(), stderr); err != nil {
+		if _, err := io.Copy(errOut, stderr); err != nil {
 			logger.WithError(err).Errorf("failed to write stderr to session.")
 		}
 	}()
 
 	wg.Wait()
 
-	if err := cmd.Wait(); err != nil {
+	if err := waitTracked(cmd); err != nil {
 		logger.WithError(err).Errorf("command failed while waiting")
 		return err
 	}
@@ -171,54 +667,440 @@ func handleNoTTY(logger *log.Entry, cmd *exec.Cmd, s ssh.Session) error {
 }
 
 func (srv *Server) connectionHandler(s ssh.Session) {
+	srv.handleSession(s, srv.shellFor(s.User()))
+}
+
+// handleSession runs the command of s, or an interactive session if there is
+// none, with shell
+func (srv *Server) handleSession(s ssh.Session, shell string) {
 	sessionID := uuid.New().String()
-	logger := log.WithFields(log.Fields{"session.id": sessionID})
+	connID := connectionID(s.Context())
+	// remote addresses are always logged as the numeric String() of the
+	// address, never resolved, so no DNS lookups happen during session setup
+	label := sessionLabel(s.Environ())
+	sessionFields := log.Fields{
+		"session.id":     sessionID,
+		"connection.id":  connID,
+		"remote.address": s.RemoteAddr().String(),
+		"user":           s.User(),
+	}
+	if label != "" {
+		sessionFields["session.label"] = label
+	}
+
+	logger := log.WithFields(srv.logFields(sessionFields))
+	// every write to the session goes through s from here on
+	s = newSyncSession(s)
+	var closeSessionLog func()
+	if srv.PerSessionLogDir != "" {
+		sessionLogger, closeLog, err := srv.openSessionLog(sessionID, s.User(), logger.Data)
+		if err != nil {
+			logger.WithError(err).Error("failed to open the session log")
+		} else {
+			logger, closeSessionLog = sessionLogger, closeLog
+		}
+	}
+
+	start := time.Now()
+	endReason := EndReasonRejected
+	exitCode := ExitCodeRejected
+	var commandStart, commandEnd time.Time
+	closeFields := log.Fields{}
 	defer func() {
 		s.Close()
-		logger.Info("session closed")
+		fields := log.Fields{
+			"duration":   time.Since(start).String(),
+			"end.reason": endReason,
+		}
+		if !commandStart.IsZero() {
+			fields["command.start"] = commandStart.Format(time.RFC3339Nano)
+			fields["command.end"] = commandEnd.Format(time.RFC3339Nano)
+			fields["command.duration"] = commandEnd.Sub(commandStart).String()
+		}
+
+		for k, v := range closeFields {
+			fields[k] = v
+		}
+
+		level := log.InfoLevel
+		if endReason == EndReasonExit || endReason == EndReasonCommandError {
+			level = srv.routineLevel()
+		}
+
+		closed := logger.WithFields(srv.logFields(fields))
+		closed.Log(level, "session closed")
+		srv.writeAccessLog(s, start, exitCode)
+		srv.writeAuditLog(logger, newAuditRecord(s, sessionID, start, exitCode))
+		if closeSessionLog != nil {
+			log.WithFields(closed.Data).Log(level, "session closed")
+			closeSessionLog()
+		}
 	}()
 
-	logger.Infof("starting ssh session with command '%+v'", s.RawCommand())
+	logger.Logf(srv.routineLevel(), "starting ssh session with command '%+v'", srv.redact(s.RawCommand()))
+	if closeSessionLog != nil {
+		log.WithFields(logger.Data).Logf(srv.routineLevel(), "starting ssh session with command '%+v'", srv.redact(s.RawCommand()))
+	}
+
+	srv.emit(Event{Type: EventSessionStart, SessionID: sessionID, User: s.User(), RemoteAddr: s.RemoteAddr().String()})
+	defer srv.emit(Event{Type: EventSessionEnd, SessionID: sessionID, User: s.User()})
 
-	cmd := srv.buildCmd(s)
+	if srv.draining() {
+		exitCode = ExitCodeBusy
+		srv.countRejection(RejectDrain)
+		rejectSessionWithCode(logger, s, "the server is shutting down, try again later", ExitCodeBusy)
+		return
+	}
+
+	if srv.LoadShedFunc != nil && srv.LoadShedFunc() {
+		exitCode = ExitCodeBusy
+		srv.countRejection(RejectLoadShed)
+		rejectSessionWithCode(logger, s, "server busy, try again later", ExitCodeBusy)
+		return
+	}
+
+	if sftpOnly, _ := s.Context().Value(sftpOnlyKey).(bool); sftpOnly {
+		srv.countRejection(RejectSFTPOnly)
+		rejectSession(logger, s, "this key is only allowed to use sftp")
+		return
+	}
+
+	if n := atomic.AddInt64(&srv.openSessions, 1); srv.MaxSessions > 0 && n > int64(srv.MaxSessions) {
+		atomic.AddInt64(&srv.openSessions, -1)
+		srv.countRejection(RejectCapacity)
+		rejectSession(logger, s, fmt.Sprintf("the server reached its limit of %d sessions, try again later", srv.MaxSessions))
+		return
+	}
+
+	defer atomic.AddInt64(&srv.openSessions, -1)
+
+	if srv.MaxCommandLength > 0 && len(s.RawCommand()) > srv.MaxCommandLength {
+		srv.countRejection(RejectCommandLength)
+		rejectSession(logger, s, fmt.Sprintf("command is longer than the maximum of %d characters", srv.MaxCommandLength))
+		return
+	}
+
+	if n := len(s.Environ()); srv.MaxEnvVars > 0 && n > srv.MaxEnvVars && !srv.DropExtraEnvVars {
+		logger.Warningf("client sent %d environment variables, over the limit of %d", n, srv.MaxEnvVars)
+		srv.countRejection(RejectEnvVars)
+		rejectSession(logger, s, fmt.Sprintf("more than the maximum of %d environment variables were sent", srv.MaxEnvVars))
+		return
+	}
+
+	if size := envSize(s.Environ()); srv.MaxEnvSize > 0 && size > srv.MaxEnvSize {
+		logger.Warningf("client sent %d bytes of environment, over the limit of %d", size, srv.MaxEnvSize)
+		srv.countRejection(RejectEnvSize)
+		rejectSession(logger, s, fmt.Sprintf("environment is larger than the maximum of %d bytes", srv.MaxEnvSize))
+		return
+	}
+
+	if srv.DisableInteractiveShell && s.RawCommand() == "" {
+		srv.countRejection(RejectInteractiveShell)
+		rejectSession(logger, s, "interactive shells are disabled on this server, run a command instead")
+		return
+	}
+
+	ptyReq, winCh, isPty := s.Pty()
+	info := SessionInfo{
+		ID:           sessionID,
+		ConnectionID: connID,
+		User:         s.User(),
+		RemoteAddr:   s.RemoteAddr().String(),
+		Command:      s.RawCommand(),
+		PTY:          isPty,
+		Label:        label,
+	}
+
+	if srv.AuthorizeSession != nil {
+		if ok, reason := srv.AuthorizeSession(info); !ok {
+			srv.countRejection(RejectUnauthorized)
+			rejectSession(logger, s, reason)
+			return
+		}
+	}
+
+	logger.WithField(srv.logFieldName("env"), redactEnv(s.Environ(), srv.EnvLogDenylist)).Debug("session environment")
+	if srv.AliveInterval > 0 {
+		aliveCtx, stopAlive := context.WithCancel(s.Context())
+		defer stopAlive()
+		go sendAlive(aliveCtx, logger, srv.AliveInterval)
+	}
+
+	cmd := srv.buildCmd(logger, s, shell)
+	logger = logger.WithField(srv.logFieldName("command.path"), commandPath(cmd))
+	if cmd.Err != nil {
+		logger.WithError(cmd.Err).Warning("the shell wasn't found")
+	}
+	if srv.ScratchDirBase != "" {
+		dir, err := ioutil.TempDir(srv.ScratchDirBase, "okteto-scratch-")
+		if err != nil {
+			logger.WithError(err).Error("failed to create scratch directory")
+			endReason = EndReasonInternalError
+			exitCode = ExitCodeInternalError
+			sendErrAndExit(logger, s, err)
+			return
+		}
+
+		defer func() {
+			if err := os.RemoveAll(dir); err != nil {
+				logger.WithError(err).Errorf("failed to remove scratch directory %s", dir)
+			}
+		}()
+
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", scratchDirEnv, dir))
+	}
 
 	if ssh.AgentRequested(s) {
 		logger.Info("agent requested")
-		l, err := ssh.NewAgentListener()
-		if err != nil {
+		l, err := newAgentListener()
+		switch {
+		case err != nil && srv.RequireAgentForwarding:
 			logger.WithError(err).Error("failed to start agent")
+			endReason = EndReasonInternalError
+			exitCode = ExitCodeInternalError
+			sendErrAndExit(logger, s, err)
 			return
+		case err != nil:
+			logger.WithError(err).Warning("failed to start agent, running the session without agent forwarding")
+		default:
+			defer l.Close()
+			go ssh.ForwardAgentConnections(l, s)
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", "SSH_AUTH_SOCK", l.Addr().String()))
 		}
+	}
 
-		defer l.Close()
-		go ssh.ForwardAgentConnections(l, s)
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", "SSH_AUTH_SOCK", l.Addr().String()))
+	// registered after the scratch directory and the agent so it runs while
+	// they're still there, and it reads the environment once it's complete
+	if srv.PreCloseCommand != "" {
+		defer func() { srv.runPreClose(logger, cmd.Env) }()
 	}
 
-	ptyReq, winCh, isPty := s.Pty()
+	srv.emit(Event{Type: EventExec, SessionID: sessionID, User: s.User(), Command: srv.redact(s.RawCommand())})
+
+	sess := s
+	var monitor *activityMonitor
+	if srv.SessionIdleTimeout > 0 {
+		monitor = newActivityMonitor(srv.SessionIdleTimeout, func() {
+			logger.Infof("session had no activity for %s, terminating the command", srv.SessionIdleTimeout)
+			if err := terminateTracked(logger, cmd, srv.KillGrace); err != nil {
+				logger.WithError(err).Error("failed to terminate idle command")
+			}
+		})
+		defer monitor.stop()
+		sess = monitor.wrap(s)
+	}
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-s.Context().Done():
+			logger.Info("client disconnected, terminating the command")
+			if err := terminateTracked(logger, cmd, srv.KillGrace); err != nil {
+				logger.WithError(err).Error("failed to terminate command")
+			}
+		case <-finished:
+		}
+	}()
+
+	active := &activeSession{info: info, start: start, kill: func() {
+		if err := terminateTracked(logger, cmd, srv.KillGrace); err != nil {
+			logger.WithError(err).Error("failed to terminate killed command")
+		}
+	}}
+	srv.sessions.Store(sessionID, active)
+	defer srv.sessions.Delete(sessionID)
+
+	if srv.SessionBufferBytes > 0 {
+		buffer := newRingBuffer(srv.SessionBufferBytes)
+		srv.sessionBuffers.Store(sessionID, buffer)
+		defer srv.sessionBuffers.Delete(sessionID)
+		sess = &teeSession{Session: sess, w: buffer}
+	}
+
+	if isPty && len(ptyReq.Term) > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("TERM=%s", ptyReq.Term))
+	}
+
+	if srv.EnvSanitizer != nil {
+		cmd.Env = srv.EnvSanitizer(cmd.Env)
+	}
+
+	var err error
+	forwarded := &forwardedSignals{}
+	commandStart = time.Now()
 	if isPty {
-		logger.Println("handling PTY session")
-		if err := handlePTY(logger, cmd, s, ptyReq, winCh); err != nil {
-			sendErrAndExit(logger, s, err)
-			return
+		logger.Log(srv.routineLevel(), "handling PTY session")
+		err = srv.handlePTY(logger, info, active, cmd, sess, ptyReq, winCh)
+	} else {
+		logger.Log(srv.routineLevel(), "handling non PTY session")
+		err = srv.handleNoTTY(logger, active, cmd, sess, forwarded)
+	}
+
+	commandEnd = time.Now()
+	for k, v := range resourceUsage(cmd.ProcessState) {
+		closeFields[k] = v
+	}
+
+	endReason = sessionEndReason(s.Context(), err)
+	idle := monitor != nil && monitor.idle()
+	if idle {
+		endReason = EndReasonIdleTimeout
+	}
+
+	if reason := active.terminatedFor(); reason != "" {
+		endReason = reason
+	}
+
+	// the server kills commands for a reason, and the client's signals are
+	// expected, any other signal that killed the shell crashed it (e.g. a
+	// segfault), as opposed to exiting with an error
+	if sig, ok := exitSignal(err); ok && endReason == EndReasonCommandError && !forwarded.has(sig) {
+		closeFields["shell.crashed"] = true
+		closeFields["shell.signal"] = sig.String()
+		closeFields["shell.pid"] = cmd.Process.Pid
+		logger.WithFields(srv.logFields(log.Fields{"shell.signal": sig.String(), "shell.pid": cmd.Process.Pid})).Warning("the shell was killed by a signal")
+	}
+
+	// connections closed by IdleTimeout can't be told anything
+	if isPty && idle && endReason == EndReasonIdleTimeout {
+		// PTY clients read the terminal from the session output, not stderr
+		exitCode = srv.timeoutExitCode()
+		msg := fmt.Sprintf("\r\nsession terminated after %s without activity\r\n", srv.SessionIdleTimeout)
+		if _, err := sess.Write([]byte(msg)); err != nil {
+			logger.WithError(err).Errorf("failed to write timeout back to session")
+		}
+
+		if err := s.Exit(exitCode); err != nil {
+			logger.WithError(err).Errorf("pty session failed to exit")
 		}
 
-		s.Exit(0)
 		return
 	}
 
-	logger.Println("handling non PTY session")
-	if err := handleNoTTY(logger, cmd, s); err != nil {
+	if err != nil {
+		exitCode = getExitStatusFromError(err)
 		sendErrAndExit(logger, s, err)
 		return
 	}
 
+	exitCode = 0
 	s.Exit(0)
 }
 
+func (srv *Server) timeoutExitCode() int {
+	if srv.TimeoutExitCode != 0 {
+		return srv.TimeoutExitCode
+	}
+
+	return ExitCodeTimeout
+}
+
+func (srv *Server) runPreClose(logger *log.Entry, env []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), preCloseTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, srv.Shell, "-c", srv.PreCloseCommand)
+	cmd.Env = env
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := startTracked(cmd, cmd.Start)
+	if err == nil {
+		err = waitTracked(cmd)
+	}
+
+	logger = logger.WithField("output", srv.redact(out.String()))
+	if err != nil {
+		logger.WithError(err).Errorf("pre-close command '%s' failed", srv.PreCloseCommand)
+		return
+	}
+
+	logger.Logf(srv.routineLevel(), "pre-close command '%s' finished", srv.PreCloseCommand)
+}
+
+// DefaultAuthorizedKeysPath is the authorized_keys file used when
+// AuthorizedKeysPath is not set
+const DefaultAuthorizedKeysPath = "/var/okteto/remote/authorized_keys"
+
+// LoadAuthorizedKeysFile loads AuthorizedKeys and SFTPOnlyKeys from
+// AuthorizedKeysPath. Authentication stays disabled if the file doesn't exist.
+func (srv *Server) LoadAuthorizedKeysFile() error {
+	path := srv.AuthorizedKeysPath
+	if path == "" {
+		path = DefaultAuthorizedKeysPath
+	}
+
+	if err := CheckAuthorizedKeysPermissions(path); err != nil {
+		if srv.StrictModes {
+			return err
+		}
+
+		log.WithError(err).Warning("authorized_keys has unsafe permissions")
+	}
+
+	entries, err := LoadAuthorizedKeyEntries(path)
+	if err != nil {
+		return err
+	}
+
+	srv.SetAuthorizedKeyEntries(entries)
+	return nil
+}
+
+// SetAuthorizedKeyEntries sets AuthorizedKeys and SFTPOnlyKeys from the
+// entries of an authorized_keys file. Authentication is disabled if entries
+// is nil.
+func (srv *Server) SetAuthorizedKeyEntries(entries []AuthorizedKey) {
+	srv.AuthorizedKeys, srv.SFTPOnlyKeys = nil, nil
+	for _, e := range entries {
+		srv.AuthorizedKeys = append(srv.AuthorizedKeys, e.PublicKey)
+		if e.HasOption(SFTPOnlyOption) {
+			srv.SFTPOnlyKeys = append(srv.SFTPOnlyKeys, e.PublicKey)
+		}
+	}
+}
+
+// SFTPOnlyOption is the authorized_keys option that restricts a key to the
+// sftp subsystem
+const SFTPOnlyOption = "sftp-only"
+
+// AuthorizedKey is an entry of an authorized_keys file
+type AuthorizedKey struct {
+	ssh.PublicKey
+	Options []string
+}
+
+// HasOption returns true if the entry has the option
+func (k AuthorizedKey) HasOption(option string) bool {
+	for _, o := range k.Options {
+		if o == option {
+			return true
+		}
+	}
+
+	return false
+}
+
 // LoadAuthorizedKeys loads path as an array.
 // It will return nil if path doesn't exist.
 func LoadAuthorizedKeys(path string) ([]ssh.PublicKey, error) {
+	entries, err := LoadAuthorizedKeyEntries(path)
+	if err != nil || entries == nil {
+		return nil, err
+	}
+
+	authorizedKeys := make([]ssh.PublicKey, 0, len(entries))
+	for _, e := range entries {
+		authorizedKeys = append(authorizedKeys, e.PublicKey)
+	}
+
+	return authorizedKeys, nil
+}
+
+// LoadAuthorizedKeyEntries loads path with the options of each key.
+// It will return nil if path doesn't exist.
+func LoadAuthorizedKeyEntries(path string) ([]AuthorizedKey, error) {
 	authorizedKeysBytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -228,111 +1110,360 @@ func LoadAuthorizedKeys(path string) ([]ssh.PublicKey, error) {
 		return nil, err
 	}
 
-	authorizedKeys := []ssh.PublicKey{}
+	entries, err := ParseAuthorizedKeyEntries(authorizedKeysBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s was empty", path)
+	}
+
+	return entries, nil
+}
+
+// ParseAuthorizedKeys parses the keys in the authorized_keys format, one per
+// line
+func ParseAuthorizedKeys(authorizedKeysBytes []byte) ([]ssh.PublicKey, error) {
+	entries, err := ParseAuthorizedKeyEntries(authorizedKeysBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizedKeys := make([]ssh.PublicKey, 0, len(entries))
+	for _, e := range entries {
+		authorizedKeys = append(authorizedKeys, e.PublicKey)
+	}
+
+	return authorizedKeys, nil
+}
+
+// ParseAuthorizedKeyEntries parses the keys in the authorized_keys format with
+// their options
+func ParseAuthorizedKeyEntries(authorizedKeysBytes []byte) ([]AuthorizedKey, error) {
+	authorizedKeysBytes = bytes.TrimSpace(authorizedKeysBytes)
+	entries := []AuthorizedKey{}
 	for len(authorizedKeysBytes) > 0 {
-		pubKey, _, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
+		pubKey, _, options, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
 		if err != nil {
 			return nil, err
 		}
 
-		authorizedKeys = append(authorizedKeys, pubKey)
+		entries = append(entries, AuthorizedKey{PublicKey: pubKey, Options: options})
 		authorizedKeysBytes = rest
 	}
 
-	if len(authorizedKeys) == 0 {
-		return nil, fmt.Errorf("%s was empty", path)
+	return entries, nil
+}
+
+func (srv *Server) authorize(ctx ssh.Context, key ssh.PublicKey) bool {
+	user := ""
+	if ctx != nil {
+		user = ctx.User()
 	}
 
-	return authorizedKeys, nil
+	ok, reason := srv.CheckKey(user, key)
+	if !ok {
+		log.Printf("access denied: %s", reason)
+	}
+
+	// the key that signs is not necessarily the last one checked, so once
+	// an sftp-only key is accepted the whole connection is restricted
+	if ok && ctx != nil && srv.isSFTPOnly(key) {
+		ctx.SetValue(sftpOnlyKey, true)
+	}
+
+	srv.emitAuth(ctx, ok)
+	return ok
 }
 
-func (srv *Server) authorize(ctx ssh.Context, key ssh.PublicKey) bool {
+// CheckKey reports whether key would be accepted for user, and why, using the
+// same policy as the live authentication. Like it, the options of the
+// authorized keys, such as from=, aren't evaluated.
+func (srv *Server) CheckKey(user string, key ssh.PublicKey) (bool, string) {
+	if !srv.authEnabled() {
+		return true, "authentication is disabled"
+	}
+
+	if cert, ok := key.(*gossh.Certificate); ok && len(srv.TrustedUserCAKeys) > 0 {
+		return srv.checkCertificate(user, cert)
+	}
+
 	for _, k := range srv.AuthorizedKeys {
+		if ssh.KeysEqual(key, k) {
+			return true, "key is in authorized_keys"
+		}
+	}
+
+	return false, "key is not in authorized_keys"
+}
+
+func (srv *Server) isSFTPOnly(key ssh.PublicKey) bool {
+	for _, k := range srv.SFTPOnlyKeys {
 		if ssh.KeysEqual(key, k) {
 			return true
 		}
 	}
 
-	log.Println("access denied")
 	return false
 }
 
+func (srv *Server) emitAuth(ctx ssh.Context, success bool) {
+	ev := Event{Type: EventAuth, Success: &success}
+	if ctx != nil {
+		ev.User = ctx.User()
+		ev.RemoteAddr = ctx.RemoteAddr().String()
+	}
+
+	srv.emit(ev)
+}
+
+func (srv *Server) authEnabled() bool {
+	return srv.AuthorizedKeys != nil || len(srv.TrustedUserCAKeys) > 0 || srv.Password != ""
+}
+
 // ListenAndServe starts the SSH server using port
 func (srv *Server) ListenAndServe() error {
+	if err := srv.checkRoot(); err != nil {
+		return err
+	}
+
+	if err := srv.loadHostKeys(); err != nil {
+		return err
+	}
+
+	if err := srv.validateShellSubsystems(); err != nil {
+		return err
+	}
+
+	if srv.ReapOrphans || os.Getpid() == 1 {
+		if err := startReaper(os.Getpid() != 1); err != nil {
+			log.WithError(err).Warning("orphaned processes won't be reaped")
+		}
+	}
+
+	if srv.ReadinessCommand != "" {
+		stop := make(chan struct{})
+		srv.mu.Lock()
+		srv.stopReadiness = stop
+		srv.mu.Unlock()
+		go srv.runReadinessProbe(stop)
+	}
+
+	if srv.AdminSocketPath != "" {
+		l, err := srv.listenAdmin()
+		if err != nil {
+			return err
+		}
+
+		srv.mu.Lock()
+		srv.adminListener = l
+		srv.mu.Unlock()
+		go srv.serveAdmin(l)
+	}
+
 	server := srv.getServer()
-	return server.ListenAndServe()
+	l, err := srv.listen(server.Addr)
+	if err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	srv.server = server
+	srv.mu.Unlock()
+
+	if srv.HealthPort > 0 {
+		if err := srv.listenHealth(); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	return server.Serve(l)
 }
 
 func (srv *Server) getServer() *ssh.Server {
-	forwardHandler := &ssh.ForwardedTCPHandler{}
-
 	server := &ssh.Server{
-		Addr:    fmt.Sprintf(":%d", srv.Port),
+		Addr:    net.JoinHostPort(srv.BindAddress, strconv.Itoa(srv.Port)),
 		Handler: srv.connectionHandler,
 		ChannelHandlers: map[string]ssh.ChannelHandler{
-			"direct-tcpip": ssh.DirectTCPIPHandler,
+			"direct-tcpip": srv.directTCPIPHandler,
 			"session":      ssh.DefaultSessionHandler,
 		},
 		LocalPortForwardingCallback: ssh.LocalPortForwardingCallback(func(ctx ssh.Context, dhost string, dport uint32) bool {
-			log.Println("Accepted forward", dhost, dport)
-			return true
+			return srv.allowForward(ctx, forwardLocal, dhost, dport)
 		}),
 		ReversePortForwardingCallback: ssh.ReversePortForwardingCallback(func(ctx ssh.Context, host string, port uint32) bool {
-			log.Println("attempt to bind", host, port, "granted")
-			return true
+			return srv.allowForward(ctx, forwardReverse, host, port)
 		}),
 		RequestHandlers: map[string]ssh.RequestHandler{
-			"tcpip-forward":        forwardHandler.HandleSSHRequest,
-			"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
-		},
-		SubsystemHandlers: map[string]ssh.SubsystemHandler{
-			"sftp": sftpHandler,
+			"tcpip-forward":        srv.handleReverseForward,
+			"cancel-tcpip-forward": srv.handleReverseForward,
+			infoRequest:            srv.handleInfoRequest,
 		},
+		SubsystemHandlers:    map[string]ssh.SubsystemHandler{},
+		IdleTimeout:          srv.IdleTimeout,
+		ServerConfigCallback: srv.serverConfig,
+		ConnCallback:         srv.connCallback,
 	}
 
-	server.SetOption(ssh.HostKeyPEM([]byte(hostKeyBytes)))
+	if srv.DisableLocalForwarding {
+		server.LocalPortForwardingCallback = func(ctx ssh.Context, dhost string, dport uint32) bool {
+			srv.forwardLogger(ctx, forwardLocal, dhost, dport).Info("local port forwarding refused")
+			return false
+		}
+		delete(server.ChannelHandlers, "direct-tcpip")
+	}
+
+	if srv.DisableReverseForwarding {
+		server.ReversePortForwardingCallback = func(ctx ssh.Context, host string, port uint32) bool {
+			srv.forwardLogger(ctx, forwardReverse, host, port).Info("reverse port forwarding refused")
+			return false
+		}
+		delete(server.RequestHandlers, "tcpip-forward")
+		delete(server.RequestHandlers, "cancel-tcpip-forward")
+	}
+
+	if srv.DisablePTY {
+		server.PtyCallback = func(ctx ssh.Context, pty ssh.Pty) bool {
+			log.WithFields(srv.logFields(log.Fields{
+				"connection.id":  connectionID(ctx),
+				"remote.address": ctx.RemoteAddr().String(),
+				"user":           ctx.User(),
+			})).Info("pty request refused")
+			return false
+		}
+	}
+
+	switch {
+	case srv.DisableSFTP:
+		log.Info("sftp is disabled")
+	case srv.authEnabled():
+		server.SubsystemHandlers["sftp"] = srv.sftpSubsystemHandler()
+	case srv.AllowSFTPWithoutAuth:
+		log.Warning("sftp is enabled without authentication, anyone can read and write files in this container")
+		server.SubsystemHandlers["sftp"] = srv.sftpSubsystemHandler()
+	default:
+		log.Info("sftp is disabled because authentication is not enabled")
+	}
+
+	for _, shell := range srv.ShellSubsystems {
+		shell := shell
+		server.SubsystemHandlers[shellSubsystem(shell)] = func(s ssh.Session) {
+			srv.handleSession(s, shell)
+		}
+	}
+
+	if srv.EnablePing {
+		server.SubsystemHandlers[pingSubsystem] = pingHandler
+	}
+
+	if len(srv.AllowedSubsystems) > 0 {
+		for name := range server.SubsystemHandlers {
+			if !matchesAny(name, srv.AllowedSubsystems) {
+				log.Infof("%s subsystem is disabled because it's not in the allowed subsystems", name)
+				delete(server.SubsystemHandlers, name)
+			}
+		}
+	}
+
+	if len(srv.hostSigners) == 0 {
+		keys := srv.HostKeys
+		if len(keys) == 0 {
+			keys = [][]byte{[]byte(hostKeyBytes)}
+		}
+
+		for _, key := range keys {
+			if err := server.SetOption(ssh.HostKeyPEM(key)); err != nil {
+				log.WithError(err).Error("failed to add host key")
+			}
+		}
+	}
+
+	for _, signer := range srv.hostSigners {
+		server.AddHostKey(signer)
+	}
 
-	if srv.AuthorizedKeys != nil {
+	if srv.EventSocketPath != "" && srv.events == nil {
+		srv.events = newEventEmitter(srv.EventSocketPath)
+	}
+
+	if srv.AuthorizedKeys != nil || len(srv.TrustedUserCAKeys) > 0 {
 		server.PublicKeyHandler = srv.authorize
 	}
 
+	if srv.Password != "" {
+		server.PasswordHandler = srv.authorizePassword
+	}
+
+	if srv.KeepAliveInterval > 0 {
+		for name, handler := range server.ChannelHandlers {
+			server.ChannelHandlers[name] = probedChannel(handler)
+		}
+
+		for name, handler := range server.RequestHandlers {
+			server.RequestHandlers[name] = probedRequest(handler)
+		}
+	}
+
 	return server
 }
 
-func sftpHandler(sess ssh.Session) {
-	debugStream := ioutil.Discard
-	serverOptions := []sftp.ServerOption{
-		sftp.WithDebug(debugStream),
-	}
-	server, err := sftp.NewServer(
-		sess,
-		serverOptions...,
-	)
-	if err != nil {
-		log.Printf("sftp server init error: %s\n", err)
-		return
+// commandPath returns the absolute path of the executable run by cmd, to help
+// debugging PATH issues. Executables that weren't found in PATH are returned
+// as they were named.
+func commandPath(cmd *exec.Cmd) string {
+	if cmd.Err != nil || !strings.ContainsRune(cmd.Path, filepath.Separator) {
+		return cmd.Path
 	}
-	if err := server.Serve(); err == io.EOF {
-		server.Close()
-		log.Println("sftp client exited session.")
-	} else if err != nil {
-		log.Println("sftp server completed with error:", err)
+
+	if p, err := filepath.Abs(cmd.Path); err == nil {
+		return p
 	}
+
+	return cmd.Path
 }
 
-func (srv Server) buildCmd(s ssh.Session) *exec.Cmd {
+func (srv *Server) buildCmd(logger *log.Entry, s ssh.Session, shell string) *exec.Cmd {
 	var cmd *exec.Cmd
 
 	if len(s.RawCommand()) == 0 {
-		cmd = exec.Command(srv.Shell)
+		cmd = exec.Command(shell)
 	} else {
-		args := []string{"-c", s.RawCommand()}
-		cmd = exec.Command(srv.Shell, args...)
+		command := s.RawCommand()
+		if srv.ExecPrefix != "" {
+			command = fmt.Sprintf("%s %s", srv.ExecPrefix, command)
+		}
+
+		args := []string{"-c", command}
+		cmd = exec.Command(shell, args...)
+	}
+
+	if !srv.DisableServerEnv {
+		cmd.Env = append(cmd.Env, os.Environ()...)
+	}
+
+	clientEnv, malformed := splitMalformedEnv(s.Environ())
+	for _, kv := range malformed {
+		// only the name is logged, the value might be a secret, and entries
+		// without a name are only measured
+		if !strings.Contains(kv, "=") {
+			logger.Warningf("dropped malformed environment entry of %d bytes without '='", len(kv))
+			continue
+		}
+
+		logger.Warningf("dropped malformed environment variable %q", envName(kv))
 	}
 
-	cmd.Env = append(cmd.Env, os.Environ()...)
-	cmd.Env = append(cmd.Env, s.Environ()...)
+	if srv.AllowedEnv != nil {
+		clientEnv = filterEnv(clientEnv, srv.AllowedEnv)
+	}
+
+	if srv.MaxEnvVars > 0 && len(clientEnv) > srv.MaxEnvVars {
+		logger.Warningf("dropped %d environment variables over the limit of %d", len(clientEnv)-srv.MaxEnvVars, srv.MaxEnvVars)
+		clientEnv = clientEnv[:srv.MaxEnvVars]
+	}
 
-	fmt.Println(cmd.String())
+	cmd.Env = append(cmd.Env, clientEnv...)
+	cmd.Env = append(cmd.Env, loginEnv(cmd.Env, shell)...)
 	return cmd
 }
@@ -2,14 +2,22 @@ package ssh
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gliderlabs/ssh"
+	remoteLog "github.com/okteto/remote/pkg/log"
+	log "github.com/sirupsen/logrus"
 	gossh "golang.org/x/crypto/ssh"
 )
 
@@ -124,6 +132,90 @@ func TestLoadAuthorizedKeys_multiple(t *testing.T) {
 	}
 }
 
+func TestLoadAuthorizedKeyEntries_options(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	if err := ioutil.WriteFile(path, []byte(SFTPOnlyOption+",no-pty "+goodKey+"\n"+goodKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := LoadAuthorizedKeyEntries(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("loaded %d keys, expected 2", len(entries))
+	}
+
+	if !entries[0].HasOption(SFTPOnlyOption) || !entries[0].HasOption("no-pty") {
+		t.Errorf("options weren't loaded: %v", entries[0].Options)
+	}
+
+	if entries[1].HasOption(SFTPOnlyOption) {
+		t.Errorf("unexpected options: %v", entries[1].Options)
+	}
+}
+
+func TestServer_LoadAuthorizedKeysFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custom_keys")
+	if err := ioutil.WriteFile(path, []byte(goodKey+"\n"+SFTPOnlyOption+" "+goodKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &Server{AuthorizedKeysPath: path}
+	if err := srv.LoadAuthorizedKeysFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv.AuthorizedKeys) != 2 || len(srv.SFTPOnlyKeys) != 1 {
+		t.Errorf("loaded %d keys and %d sftp-only keys", len(srv.AuthorizedKeys), len(srv.SFTPOnlyKeys))
+	}
+
+	srv = &Server{AuthorizedKeysPath: filepath.Join(t.TempDir(), "missing")}
+	if err := srv.LoadAuthorizedKeysFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if srv.authEnabled() {
+		t.Error("authentication is enabled without an authorized_keys file")
+	}
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input string
+		keys  int
+		err   bool
+	}{
+		{name: "multiple", input: goodKey + "\n" + badKey + "\n", keys: 2},
+		{name: "blank-lines-and-comments", input: "\n# comment\n" + goodKey + "\n\n" + badKey + "\n\n", keys: 2},
+		{name: "empty", input: "\n"},
+		{name: "malformed", input: goodKey + "\nssh-ed25519 not-base64\n", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseAuthorizedKeys([]byte(tt.input))
+			if tt.err {
+				if err == nil {
+					t.Error("malformed input was parsed")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(keys) != tt.keys {
+				t.Errorf("parsed %d keys, expected %d", len(keys), tt.keys)
+			}
+		})
+	}
+}
+
 func Test_connectionHandler(t *testing.T) {
 
 	var tests = []struct {
@@ -207,9 +299,11 @@ func serveOnce(srv *ssh.Server, l net.Listener) error {
 	if e != nil {
 		return e
 	}
-	srv.ChannelHandlers = map[string]ssh.ChannelHandler{
-		"session":      ssh.DefaultSessionHandler,
-		"direct-tcpip": ssh.DirectTCPIPHandler,
+	if srv.ChannelHandlers == nil {
+		srv.ChannelHandlers = map[string]ssh.ChannelHandler{
+			"session":      ssh.DefaultSessionHandler,
+			"direct-tcpip": ssh.DirectTCPIPHandler,
+		}
 	}
 	srv.HandleConn(conn)
 	return nil
@@ -255,3 +349,700 @@ func newClientSession(t *testing.T, addr string, config *gossh.ClientConfig) (*g
 		client.Close()
 	}
 }
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs redirects the standard logger to a buffer for the duration of the test
+func captureLogs(t *testing.T) *syncBuffer {
+	buf := &syncBuffer{}
+	out := log.StandardLogger().Out
+	level := log.GetLevel()
+	log.SetOutput(buf)
+	log.SetLevel(log.DebugLevel)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetLevel(level)
+	})
+
+	return buf
+}
+
+// newTestSigner returns a fresh client key and its public key
+func newTestSigner(t *testing.T) (gossh.Signer, ssh.PublicKey) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer, signer.PublicKey()
+}
+
+func clientConfigWithKey(signer gossh.Signer) *gossh.ClientConfig {
+	return &gossh.ClientConfig{
+		User: "okteto",
+		Auth: []gossh.AuthMethod{gossh.PublicKeys(signer)},
+	}
+}
+
+func Test_serverConfigCallback(t *testing.T) {
+	s := &Server{
+		Shell: "sh",
+		ServerConfigCallback: func(ctx ssh.Context) *gossh.ServerConfig {
+			cfg := &gossh.ServerConfig{}
+			cfg.Ciphers = []string{"aes128-ctr"}
+			return cfg
+		},
+	}
+
+	srv := s.getServer()
+	l := newLocalListener()
+	go serveOnce(srv, l)
+
+	cfg := &gossh.ClientConfig{HostKeyCallback: gossh.InsecureIgnoreHostKey()}
+	cfg.Ciphers = []string{"aes256-ctr"}
+	if c, err := gossh.Dial("tcp", l.Addr().String(), cfg); err == nil {
+		c.Close()
+		t.Fatal("handshake succeeded with a cipher the server config doesn't allow")
+	}
+}
+
+func Test_connectionHandler_preCloseCommand(t *testing.T) {
+	dir := t.TempDir()
+	done := filepath.Join(dir, "done")
+	cleaned := filepath.Join(dir, "cleaned")
+
+	s := &Server{Shell: "sh", PreCloseCommand: fmt.Sprintf("test -f %s && touch %s", done, cleaned)}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run(fmt.Sprintf("touch %s", done)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(cleaned); err == nil {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("pre-close command didn't run after the session command")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_connectionHandler_preCloseCommandEnv(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "term")
+	s := &Server{Shell: "sh", PreCloseCommand: fmt.Sprintf(`echo "$TERM" > %s`, out)}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	// TERM is only added to the environment when the PTY is started
+	if err := session.RequestPty("vt220", 40, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if content, err := ioutil.ReadFile(out); err == nil && len(content) > 0 {
+			if term := strings.TrimSpace(string(content)); term != "vt220" {
+				t.Errorf("the pre-close command got TERM=%q, expected the environment of the session", term)
+			}
+
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("pre-close command didn't run")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_connectionHandler_exitCodes(t *testing.T) {
+	var tests = []struct {
+		name     string
+		shell    string
+		command  string
+		pty      bool
+		expected int
+	}{
+		{
+			name:     "command-exit-code",
+			shell:    "sh",
+			command:  "exit 3",
+			expected: 3,
+		},
+		{
+			name:     "pty-command-exit-code",
+			shell:    "sh",
+			command:  "exit 3",
+			pty:      true,
+			expected: 3,
+		},
+		{
+			name:     "internal-error",
+			shell:    "/nonexistent/shell",
+			command:  "echo hi",
+			expected: ExitCodeInternalError,
+		},
+		{
+			name:     "pty-internal-error",
+			shell:    "/nonexistent/shell",
+			command:  "echo hi",
+			pty:      true,
+			expected: ExitCodeInternalError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: tt.shell}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			if tt.pty {
+				if err := session.RequestPty("xterm", 40, 80, gossh.TerminalModes{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := session.Run(tt.command)
+			exitErr, ok := err.(*gossh.ExitError)
+			if !ok {
+				t.Fatalf("expected an exit error, got %v", err)
+			}
+
+			if exitErr.ExitStatus() != tt.expected {
+				t.Errorf("got exit code %d, expected %d", exitErr.ExitStatus(), tt.expected)
+			}
+		})
+	}
+}
+
+func Test_handlePTY_stderr(t *testing.T) {
+	var tests = []struct {
+		name           string
+		separateStderr bool
+	}{
+		{name: "merged"},
+		{name: "separate", separateStderr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", PTYSeparateStderr: tt.separateStderr}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			if err := session.RequestPty("xterm", 40, 80, gossh.TerminalModes{}); err != nil {
+				t.Fatal(err)
+			}
+
+			var stdout, stderr bytes.Buffer
+			session.Stdout = &stdout
+			session.Stderr = &stderr
+			if err := session.Run("echo oops >&2"); err != nil {
+				t.Fatal(err)
+			}
+
+			got := stdout.String()
+			if tt.separateStderr {
+				got = stderr.String()
+			}
+
+			if !strings.Contains(got, "oops") {
+				t.Errorf("stderr didn't reach the client. stdout: %q stderr: %q", stdout.String(), stderr.String())
+			}
+		})
+	}
+}
+
+type blockedWriter struct {
+	unblock chan struct{}
+}
+
+func (b *blockedWriter) Write(p []byte) (int, error) {
+	<-b.unblock
+	return len(p), nil
+}
+
+func Test_connectionHandler_blockedLogSink(t *testing.T) {
+	sink := &blockedWriter{unblock: make(chan struct{})}
+	w := remoteLog.NewAsyncWriter(sink, 1)
+	out := log.StandardLogger().Out
+	log.SetOutput(w)
+	defer func() {
+		log.SetOutput(out)
+		close(sink.unblock)
+		w.Close()
+	}()
+
+	s := &Server{Shell: "sh"}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- session.Run("echo hi")
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was blocked by the log sink")
+	}
+
+	if strings.TrimSpace(stdout.String()) != "hi" {
+		t.Errorf("bad stdout: %q", stdout.String())
+	}
+
+	if w.Dropped() == 0 {
+		t.Error("log lines weren't dropped")
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	_, authorized := newTestSigner(t)
+	_, unknown := newTestSigner(t)
+
+	srv := Server{AuthorizedKeys: []ssh.PublicKey{authorized}}
+	if ok, reason := srv.CheckKey("okteto", authorized); !ok {
+		t.Errorf("authorized key was rejected: %s", reason)
+	}
+
+	if ok, reason := srv.CheckKey("okteto", unknown); ok || reason == "" {
+		t.Errorf("unknown key was accepted: %s", reason)
+	}
+
+	open := Server{}
+	if ok, _ := open.CheckKey("okteto", unknown); !ok {
+		t.Error("key was rejected with authentication disabled")
+	}
+}
+
+func TestCheckKey_certificate(t *testing.T) {
+	ca, caPub := newTestSigner(t)
+	userSigner, _ := newTestSigner(t)
+	now := time.Now()
+	cert := &gossh.Certificate{
+		Key:             userSigner.PublicKey(),
+		CertType:        gossh.UserCert,
+		ValidPrincipals: []string{"okteto"},
+		ValidAfter:      uint64(now.Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(now.Add(time.Hour).Unix()),
+	}
+
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		user     string
+		accepted bool
+		reason   string
+	}{
+		{name: "accepted", user: "okteto", accepted: true, reason: "certificate is signed by a trusted CA"},
+		{name: "wrong-user", user: "root", reason: `user "root" is not a principal of the certificate`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := Server{TrustedUserCAKeys: []ssh.PublicKey{caPub}}
+			ok, reason := srv.CheckKey(tt.user, cert)
+			if ok != tt.accepted || reason != tt.reason {
+				t.Errorf("got %t %q, expected %t %q", ok, reason, tt.accepted, tt.reason)
+			}
+		})
+	}
+}
+
+func Test_buildCmd_execPrefix(t *testing.T) {
+	var tests = []struct {
+		name    string
+		command string
+		stdin   string
+		stdout  string
+	}{
+		{
+			name:    "exec",
+			command: "echo hi",
+			stdout:  "prefixed echo hi",
+		},
+		{
+			name:   "interactive",
+			stdin:  "echo hi\nexit\n",
+			stdout: "hi",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", ExecPrefix: "echo prefixed"}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			var stdout bytes.Buffer
+			session.Stdout = &stdout
+			session.Stdin = strings.NewReader(tt.stdin)
+
+			var err error
+			if tt.command == "" {
+				err = session.Shell()
+				if err == nil {
+					err = session.Wait()
+				}
+			} else {
+				err = session.Run(tt.command)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if out := strings.TrimSpace(stdout.String()); out != tt.stdout {
+				t.Errorf("got %q, expected %q", out, tt.stdout)
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_maxCommandLength(t *testing.T) {
+	var tests = []struct {
+		name     string
+		command  string
+		rejected bool
+	}{
+		{name: "under-limit", command: "echo hi"},
+		{name: "over-limit", command: "echo " + strings.Repeat("a", 20), rejected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", MaxCommandLength: 10}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			var stderr bytes.Buffer
+			session.Stderr = &stderr
+			err := session.Run(tt.command)
+			if !tt.rejected {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			exitErr, ok := err.(*gossh.ExitError)
+			if !ok || exitErr.ExitStatus() != ExitCodeRejected {
+				t.Fatalf("expected exit code %d, got %v", ExitCodeRejected, err)
+			}
+
+			if !strings.Contains(stderr.String(), "longer than the maximum") {
+				t.Errorf("bad message: %q", stderr.String())
+			}
+		})
+	}
+}
+
+func Test_handlePTY_clampWindow(t *testing.T) {
+	s := &Server{Shell: "sh", MaxCols: 200, MaxRows: 100}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.RequestPty("xterm", 5000, 5000, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Run("stty size"); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := strings.TrimSpace(stdout.String()); out != "100 200" {
+		t.Errorf("got size %q, expected \"100 200\"", out)
+	}
+}
+
+func Test_handleNoTTY_stdin(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 100000)
+
+	s := &Server{Shell: "sh"}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	session.Stdin = bytes.NewReader(payload)
+	if err := session.Run("wc -c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := strings.TrimSpace(stdout.String()); out != fmt.Sprint(len(payload)) {
+		t.Errorf("got %s bytes, expected %d", out, len(payload))
+	}
+}
+
+func Test_connectionHandler_disableInteractiveShell(t *testing.T) {
+	var tests = []struct {
+		name    string
+		disable bool
+	}{
+		{name: "allowed"},
+		{name: "refused", disable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", DisableInteractiveShell: tt.disable}
+			session, _, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			var stdout, stderr bytes.Buffer
+			session.Stdout = &stdout
+			session.Stderr = &stderr
+			session.Stdin = strings.NewReader("echo hi\n")
+			if err := session.Shell(); err != nil {
+				t.Fatal(err)
+			}
+
+			err := session.Wait()
+			if !tt.disable {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if strings.TrimSpace(stdout.String()) != "hi" {
+					t.Errorf("bad stdout: %q", stdout.String())
+				}
+
+				return
+			}
+
+			exitErr, ok := err.(*gossh.ExitError)
+			if !ok || exitErr.ExitStatus() != ExitCodeRejected {
+				t.Fatalf("expected exit code %d, got %v", ExitCodeRejected, err)
+			}
+
+			if !strings.Contains(stderr.String(), "interactive shells are disabled") {
+				t.Errorf("bad message: %q", stderr.String())
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_scratchDir(t *testing.T) {
+	logs := captureLogs(t)
+	base := t.TempDir()
+
+	s := &Server{Shell: "sh", ScratchDirBase: base}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Run(`test -d "$OKTETO_SCRATCH" && touch "$OKTETO_SCRATCH/file" && echo "$OKTETO_SCRATCH"`); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := strings.TrimSpace(stdout.String())
+	if filepath.Dir(dir) != base {
+		t.Fatalf("scratch directory %q isn't in %s", dir, base)
+	}
+
+	waitForLog(t, logs, "session closed")
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("scratch directory wasn't removed: %v", err)
+	}
+}
+
+func Test_connectionHandler_scratchDirPreClose(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "scratch")
+	s := &Server{Shell: "sh", ScratchDirBase: t.TempDir(), PreCloseCommand: fmt.Sprintf(`test -f "$OKTETO_SCRATCH/file" && echo "$OKTETO_SCRATCH" > %s`, out)}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run(`touch "$OKTETO_SCRATCH/file"`); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if content, err := ioutil.ReadFile(out); err == nil && len(content) > 0 {
+			if _, err := os.Stat(strings.TrimSpace(string(content))); !os.IsNotExist(err) {
+				t.Errorf("scratch directory wasn't removed after the pre-close command: %v", err)
+			}
+
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the pre-close command didn't find the scratch directory")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_getServer_disablePTY(t *testing.T) {
+	logs := captureLogs(t)
+	s := &Server{Shell: "sh", DisablePTY: true}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.RequestPty("xterm", 40, 80, gossh.TerminalModes{}); err == nil {
+		t.Fatal("pty request was accepted")
+	}
+
+	waitForLog(t, logs, "pty request refused")
+	if !strings.Contains(logs.String(), "connection.id=") {
+		t.Errorf("the refusal wasn't logged with the connection:\n%s", logs.String())
+	}
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Run("echo hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(stdout.String()) != "hi" {
+		t.Errorf("bad stdout: %q", stdout.String())
+	}
+}
+
+func Test_getServer_disableForwarding(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+
+			c.Close()
+		}
+	}()
+
+	var tests = []struct {
+		name    string
+		local   bool
+		reverse bool
+	}{
+		{name: "enabled", local: true, reverse: true},
+		{name: "local-disabled", reverse: true},
+		{name: "reverse-disabled", local: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Shell: "sh", DisableLocalForwarding: !tt.local, DisableReverseForwarding: !tt.reverse}
+			_, client, cleanup := newTestSession(t, s.getServer(), nil)
+			defer cleanup()
+
+			c, err := client.Dial("tcp", target.Addr().String())
+			if err == nil {
+				c.Close()
+			}
+
+			if tt.local && err != nil {
+				t.Errorf("local forward was refused: %v", err)
+			} else if !tt.local && err == nil {
+				t.Error("local forward was accepted")
+			}
+
+			l, err := client.Listen("tcp", "127.0.0.1:0")
+			if err == nil {
+				l.Close()
+			}
+
+			if tt.reverse && err != nil {
+				t.Errorf("reverse forward was refused: %v", err)
+			} else if !tt.reverse && err == nil {
+				t.Error("reverse forward was accepted")
+			}
+		})
+	}
+}
+
+func Test_connectionHandler_commandPath(t *testing.T) {
+	logs := captureLogs(t)
+
+	expected, err := exec.LookPath("sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Shell: "sh"}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForLog(t, logs, "session closed")
+	if !strings.Contains(logs.String(), "command.path="+expected) {
+		t.Errorf("resolved shell path %s wasn't logged:\n%s", expected, logs.String())
+	}
+}
+
+func Test_connectionHandler_commandPathNotFound(t *testing.T) {
+	logs := captureLogs(t)
+
+	s := &Server{Shell: "okteto-missing-shell"}
+	session, _, cleanup := newTestSession(t, s.getServer(), nil)
+	defer cleanup()
+
+	if err := session.Run("true"); err == nil {
+		t.Fatal("command ran without a shell")
+	}
+
+	waitForLog(t, logs, "session closed")
+	if !strings.Contains(logs.String(), "command.path=okteto-missing-shell") {
+		t.Errorf("the shell name wasn't logged as it was named:\n%s", logs.String())
+	}
+
+	if !strings.Contains(logs.String(), "the shell wasn't found") {
+		t.Errorf("the lookup error wasn't logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,47 @@
+package ssh
+
+import (
+	"io"
+	"sync"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// syncSession serializes the writes to the stdout and stderr of a session and
+// its exit, so messages written by the server while the output of a command
+// is copied are never interleaved with it
+type syncSession struct {
+	ssh.Session
+	mu *sync.Mutex
+}
+
+func newSyncSession(s ssh.Session) *syncSession {
+	return &syncSession{Session: s, mu: &sync.Mutex{}}
+}
+
+func (s *syncSession) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Session.Write(p)
+}
+
+func (s *syncSession) Exit(code int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Session.Exit(code)
+}
+
+func (s *syncSession) Stderr() io.ReadWriter {
+	return &syncReadWriter{ReadWriter: s.Session.Stderr(), mu: s.mu}
+}
+
+type syncReadWriter struct {
+	io.ReadWriter
+	mu *sync.Mutex
+}
+
+func (w *syncReadWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ReadWriter.Write(p)
+}
@@ -0,0 +1,83 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+// byteSession writes one byte at a time to a shared buffer, so unsynchronized
+// concurrent writes are interleaved
+type byteSession struct {
+	ssh.Session
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *byteSession) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.mu.Lock()
+		s.buf.WriteByte(b)
+		s.mu.Unlock()
+		runtime.Gosched()
+	}
+
+	return len(p), nil
+}
+
+func (s *byteSession) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (s *byteSession) Stderr() io.ReadWriter {
+	return s
+}
+
+func Test_syncSession_handlePTY(t *testing.T) {
+	raw := &byteSession{}
+	s := newSyncSession(raw)
+	srv := &Server{}
+	cmd := exec.Command("sh", "-c", "i=0; while [ $i -lt 300 ]; do echo output line $i; i=$((i+1)); done")
+	winCh := make(chan ssh.Window)
+	defer close(winCh)
+	done := make(chan error)
+	go func() {
+		done <- srv.handlePTY(log.NewEntry(log.New()), SessionInfo{}, &activeSession{}, cmd, s, ssh.Pty{Window: ssh.Window{Width: 80, Height: 24}}, winCh)
+	}()
+
+	const warning = "\r\nWARNING: session will be closed\r\n"
+	for i := 0; i < 50; i++ {
+		fmt.Fprint(s.Stderr(), warning)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	raw.mu.Lock()
+	output := raw.buf.String()
+	raw.mu.Unlock()
+	if n := strings.Count(output, warning); n != 50 {
+		t.Fatalf("got %d complete warnings out of 50, the output was interleaved with them", n)
+	}
+
+	lines := strings.Fields(strings.ReplaceAll(strings.ReplaceAll(output, warning, ""), "output line", ""))
+	if len(lines) != 300 {
+		t.Fatalf("got %d output lines", len(lines))
+	}
+
+	for i, l := range lines {
+		if l != strconv.Itoa(i) {
+			t.Fatalf("output line %d is %q", i, l)
+		}
+	}
+}